@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// личность, которую service_users вернул по API-ключу
+type apiKeyIdentity struct {
+	KeyID       string    `json:"keyId"`
+	UserID      string    `json:"userId"`
+	Roles       []string  `json:"roles"`
+	Permissions []string  `json:"permissions"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type apiKeyCacheEntry struct {
+	identity *apiKeyIdentity // nil — ключ недействителен
+	until    time.Time
+}
+
+// кэш проверенных ключей: ключ кэша — sha256 API-ключа, сам ключ не храним.
+// Сбрасывается целиком по push-уведомлению об отзыве.
+type apiKeyCache struct {
+	mu      sync.Mutex
+	entries map[string]apiKeyCacheEntry
+}
+
+// сколько помнить, что ключ недействителен (защита service_users от перебора)
+const apiKeyNegativeCacheTTL = 5 * time.Second
+
+var (
+	apiKeys          = &apiKeyCache{entries: make(map[string]apiKeyCacheEntry)}
+	apiKeyClient     = &http.Client{Timeout: 5 * time.Second}
+	errInvalidAPIKey = errors.New("invalid API key")
+)
+
+func (a *apiKeyCache) get(hash string, now time.Time) (apiKeyCacheEntry, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e, ok := a.entries[hash]
+	if !ok || now.After(e.until) {
+		return apiKeyCacheEntry{}, false
+	}
+	return e, true
+}
+
+func (a *apiKeyCache) put(hash string, e apiKeyCacheEntry, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// перебор случайных ключей не должен раздувать кэш
+	if len(a.entries) >= 10000 {
+		for k, old := range a.entries {
+			if now.After(old.until) {
+				delete(a.entries, k)
+			}
+		}
+	}
+	a.entries[hash] = e
+}
+
+func (a *apiKeyCache) flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = make(map[string]apiKeyCacheEntry)
+}
+
+// API-ключ из Authorization: ApiKey ... или X-API-Key (пустая строка, если нет)
+func apiKeyFromRequest(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(parts) == 2 && strings.EqualFold(parts[0], "ApiKey") {
+		return strings.TrimSpace(parts[1])
+	}
+	return ""
+}
+
+// проверить ключ: из кэша или через service_users
+func lookupAPIKey(key string) (*apiKeyIdentity, error) {
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+	now := time.Now()
+
+	if e, ok := apiKeys.get(hash, now); ok {
+		if e.identity == nil || now.After(e.identity.ExpiresAt) {
+			return nil, errInvalidAPIKey
+		}
+		return e.identity, nil
+	}
+
+	identity, err := verifyAPIKey(key)
+	if errors.Is(err, errInvalidAPIKey) {
+		apiKeys.put(hash, apiKeyCacheEntry{until: now.Add(apiKeyNegativeCacheTTL)}, now)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys.put(hash, apiKeyCacheEntry{identity: identity, until: now.Add(apiKeyCacheTTL)}, now)
+	return identity, nil
+}
+
+// POST /internal/api-keys/verify в service_users
+func verifyAPIKey(key string) (*apiKeyIdentity, error) {
+	payload, err := json.Marshal(map[string]string{"key": key})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, apiKeyVerifyURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Internal-Token", string(internalAuthSecret))
+
+	resp, err := apiKeyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, errInvalidAPIKey
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data apiKeyIdentity `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return &body.Data, nil
+}
+
+// маршруты с apiKeys: true — API-ключ или JWT; личность сервисам уходит та же
+func APIKeyOrJWTMiddleware() gin.HandlerFunc {
+	jwtAuth := JWTMiddleware()
+
+	return func(c *gin.Context) {
+		key := apiKeyFromRequest(c)
+		if key == "" {
+			jwtAuth(c)
+			return
+		}
+
+		identity, err := lookupAPIKey(key)
+		if errors.Is(err, errInvalidAPIKey) {
+			fail(c, http.StatusUnauthorized, "INVALID_API_KEY", "API key is invalid, expired or revoked")
+			c.Abort()
+			return
+		}
+		if err != nil {
+			log.Printf("[gateway] requestId=%s API key verification via %s failed: %v", getRequestID(c), apiKeyVerifyURL, err)
+			fail(c, http.StatusServiceUnavailable, "AUTH_UNAVAILABLE", "Failed to verify API key")
+			c.Abort()
+			return
+		}
+
+		c.Set("userId", identity.UserID)
+		c.Set("roles", identity.Roles)
+		c.Set("permissions", identity.Permissions)
+		c.Set("apiKeyId", identity.KeyID)
+
+		c.Next()
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testAPIKey = "fw2_0a1b2c3d.c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0LXNlY3JldA"
+
+// service_users, который знает один ключ; revoked отзывает его
+func newTestAPIKeyServer(t *testing.T, revoked *atomic.Bool) *atomic.Int32 {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		var req struct {
+			Key string `json:"key"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Key != testAPIKey || revoked.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]any{"success": false})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "data": apiKeyIdentity{
+			KeyID: "k1", UserID: "u1", Roles: []string{"engineer"},
+			Permissions: []string{"orders:read:own"}, ExpiresAt: time.Now().Add(time.Hour),
+		}})
+	}))
+	t.Cleanup(srv.Close)
+
+	prevURL, prevTTL := apiKeyVerifyURL, apiKeyCacheTTL
+	apiKeyVerifyURL, apiKeyCacheTTL = srv.URL, time.Minute
+	t.Cleanup(func() { apiKeyVerifyURL, apiKeyCacheTTL = prevURL, prevTTL })
+	apiKeys.flush()
+	return &hits
+}
+
+func TestAPIKeyFromRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{"x-api-key", http.Header{"X-Api-Key": {testAPIKey}}, testAPIKey},
+		{"authorization", http.Header{"Authorization": {"ApiKey " + testAPIKey}}, testAPIKey},
+		{"scheme case", http.Header{"Authorization": {"apikey  " + testAPIKey}}, testAPIKey},
+		{"bearer is not a key", http.Header{"Authorization": {"Bearer eyJ..."}}, ""},
+		{"no scheme", http.Header{"Authorization": {testAPIKey}}, ""},
+		{"none", http.Header{}, ""},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.Header = tt.header
+		if got := apiKeyFromRequest(c); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAPIKeyCacheFlushedOnRevocation(t *testing.T) {
+	var revoked atomic.Bool
+	hits := newTestAPIKeyServer(t, &revoked)
+
+	for i := 0; i < 3; i++ {
+		identity, err := lookupAPIKey(testAPIKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity.UserID != "u1" || len(identity.Permissions) != 1 {
+			t.Fatalf("got identity %+v", identity)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("got %d verify calls, want 1 (cached)", n)
+	}
+
+	// ключ отозван: без уведомления шлюз ещё верит кэшу, после — спрашивает заново
+	revoked.Store(true)
+	if _, err := lookupAPIKey(testAPIKey); err != nil {
+		t.Fatalf("before notify: %v, want the cached identity", err)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/internal/revocations/notify", nil)
+	handleRevocationNotify(c)
+	if status := c.Writer.Status(); status != http.StatusAccepted {
+		t.Fatalf("notify: got %d, want 202", status)
+	}
+	if _, err := lookupAPIKey(testAPIKey); !errors.Is(err, errInvalidAPIKey) {
+		t.Fatalf("after notify: got %v, want errInvalidAPIKey", err)
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("got %d verify calls, want 2", n)
+	}
+}
+
+// неверный ключ кэшируется ненадолго: перебор не долбит service_users
+func TestInvalidAPIKeyNegativeCache(t *testing.T) {
+	var revoked atomic.Bool
+	hits := newTestAPIKeyServer(t, &revoked)
+
+	for i := 0; i < 3; i++ {
+		if _, err := lookupAPIKey("fw2_ffffffff.wrong"); !errors.Is(err, errInvalidAPIKey) {
+			t.Fatalf("got %v, want errInvalidAPIKey", err)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("got %d verify calls, want 1", n)
+	}
+}
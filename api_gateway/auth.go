@@ -12,21 +12,18 @@ import (
 type UserClaims struct {
 	UserID string   `json:"userId"`
 	Roles  []string `json:"roles"`
+	// права ролей на момент выпуска токена, например orders:update:any
+	Permissions []string `json:"permissions"`
+	SessionID   string   `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-var jwtSecret = []byte(jwtSecretString)
-
 func parseTokenGateway(tokenString string) (*UserClaims, error) {
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&UserClaims{},
-		func(token *jwt.Token) (interface{}, error) {
-			if token.Method != jwt.SigningMethodHS256 {
-				return nil, jwt.ErrTokenMalformed
-			}
-			return jwtSecret, nil
-		},
+		jwksKeyfunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}),
 	)
 	if err != nil {
 		return nil, err
@@ -47,6 +44,13 @@ func parseTokenGateway(tokenString string) (*UserClaims, error) {
 
 func JWTMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// смена пароля, 2FA, сами ключи и т.п. — только с JWT
+		if apiKeyFromRequest(c) != "" {
+			fail(c, http.StatusUnauthorized, "API_KEY_NOT_ALLOWED", "API keys are not accepted on this route")
+			c.Abort()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			fail(c, http.StatusUnauthorized, "AUTH_REQUIRED", "Missing Authorization header")
@@ -70,9 +74,68 @@ func JWTMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// можно прокинуть userId/roles дальше, если понадобится
+		if revocations.isRevoked(claims) {
+			fail(c, http.StatusUnauthorized, "TOKEN_REVOKED", "Token has been revoked")
+			c.Abort()
+			return
+		}
+
+		// userId/roles/permissions уходят сервисам в подписанных заголовках
 		c.Set("userId", claims.UserID)
 		c.Set("roles", claims.Roles)
+		c.Set("permissions", claims.Permissions)
+
+		c.Next()
+	}
+}
+
+// проверка, что у пользователя есть хотя бы одна из ролей
+func RolesRequired(allowed ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rolesVal, ok := c.Get("roles")
+		if !ok {
+			fail(c, http.StatusForbidden, "FORBIDDEN", "Roles missing in context")
+			c.Abort()
+			return
+		}
+		roles, _ := rolesVal.([]string)
+
+		for _, r := range roles {
+			for _, a := range allowed {
+				if r == a {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		fail(c, http.StatusForbidden, "FORBIDDEN", "Required role: "+strings.Join(allowed, " or "))
+		c.Abort()
+	}
+}
+
+// проверка, что у пользователя есть все перечисленные права
+func PermissionsRequired(required ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permsVal, ok := c.Get("permissions")
+		if !ok {
+			fail(c, http.StatusForbidden, "FORBIDDEN", "Permissions missing in context")
+			c.Abort()
+			return
+		}
+		permissions, _ := permsVal.([]string)
+
+		have := make(map[string]bool, len(permissions))
+		for _, p := range permissions {
+			have[p] = true
+		}
+		for _, r := range required {
+			if !have[r] {
+				fail(c, http.StatusForbidden, "FORBIDDEN", "Required permission: "+r)
+				c.Abort()
+				return
+			}
+		}
 
 		c.Next()
 	}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// повторы для идемпотентных методов (GET/HEAD/DELETE)
+type RetryConfig struct {
+	Attempts   int           `yaml:"attempts"`   // всего попыток, 1 — без повторов
+	Backoff    time.Duration `yaml:"backoff"`    // базовая задержка, растёт x2 на попытку
+	MaxBackoff time.Duration `yaml:"maxBackoff"` // потолок задержки
+}
+
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `yaml:"failureThreshold"` // подряд неудач до открытия
+	OpenTimeout      time.Duration `yaml:"openTimeout"`      // сколько держать open до half-open
+	HalfOpenRequests int           `yaml:"halfOpenRequests"` // пробных запросов в half-open
+}
+
+func (rc *RetryConfig) applyDefaults() {
+	if rc.Attempts <= 0 {
+		rc.Attempts = 3
+	}
+	if rc.Backoff <= 0 {
+		rc.Backoff = 100 * time.Millisecond
+	}
+	if rc.MaxBackoff <= 0 {
+		rc.MaxBackoff = 2 * time.Second
+	}
+}
+
+func (cc *CircuitBreakerConfig) applyDefaults() {
+	if cc.FailureThreshold <= 0 {
+		cc.FailureThreshold = 5
+	}
+	if cc.OpenTimeout <= 0 {
+		cc.OpenTimeout = 30 * time.Second
+	}
+	if cc.HalfOpenRequests <= 0 {
+		cc.HalfOpenRequests = 1
+	}
+}
+
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// задержка перед попыткой attempt (с 1): экспоненциальная с full jitter
+func retryBackoff(rc RetryConfig, attempt int) time.Duration {
+	d := rc.Backoff << (attempt - 1)
+	if d <= 0 || d > rc.MaxBackoff {
+		d = rc.MaxBackoff
+	}
+	return rand.N(d) + 1
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// circuit breaker на один upstream; живёт в реестре и переживает перезагрузку конфига
+type circuitBreaker struct {
+	name string
+
+	mu       sync.Mutex
+	cfg      CircuitBreakerConfig
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probes   int // пробные запросы в half-open
+}
+
+var (
+	breakers   = make(map[string]*circuitBreaker)
+	breakersMu sync.Mutex
+)
+
+func registryBreaker(name string, cfg CircuitBreakerConfig) *circuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	b, ok := breakers[name]
+	if !ok {
+		b = &circuitBreaker{name: name}
+		breakers[name] = b
+		setGauge("gateway_circuit_breaker_state", float64(breakerClosed), "upstream", name)
+	}
+
+	b.mu.Lock()
+	b.cfg = cfg
+	b.mu.Unlock()
+	return b
+}
+
+// забыть breaker-ы upstream-ов, которых нет в pools
+func pruneBreakers(pools map[string]*upstreamPool) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	for name := range breakers {
+		if _, ok := pools[name]; !ok {
+			delete(breakers, name)
+			deleteGauge("gateway_circuit_breaker_state", "upstream", name)
+		}
+	}
+}
+
+// можно ли отправить запрос; если нет — через сколько стоит повторить
+func (b *circuitBreaker) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		wait := b.cfg.OpenTimeout - time.Since(b.openedAt)
+		if wait > 0 {
+			return false, wait
+		}
+		b.setState(breakerHalfOpen)
+		b.probes = 1
+		return true, 0
+
+	case breakerHalfOpen:
+		if b.probes >= b.cfg.HalfOpenRequests {
+			return false, time.Second
+		}
+		b.probes++
+		return true, 0
+
+	default:
+		return true, 0
+	}
+}
+
+// можно ли повторить запрос. Повторы идут только при закрытом breaker:
+// пробные слоты half-open достаются новым запросам, а не повторам старых.
+func (b *circuitBreaker) allowRetry() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		return false, max(b.cfg.OpenTimeout-time.Since(b.openedAt), time.Second)
+	case breakerHalfOpen:
+		return false, time.Second
+	default:
+		return true, 0
+	}
+}
+
+// запрос, пропущенный через allow, закончился без результата (клиент ушёл):
+// освобождаем пробный слот, не считая ни успехом, ни отказом
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.releaseProbe()
+}
+
+// вызывается под b.mu
+func (b *circuitBreaker) releaseProbe() {
+	if b.state == breakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// результат запроса, пропущенного через allow
+func (b *circuitBreaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.releaseProbe()
+
+	if ok {
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.cfg.FailureThreshold) {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *circuitBreaker) snapshot() (breakerState, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.failures
+}
+
+// вызывается под b.mu
+func (b *circuitBreaker) setState(to breakerState) {
+	from := b.state
+	b.state = to
+
+	log.Printf("[gateway] upstream=%s circuit %s -> %s (failures=%d)", b.name, from, to, b.failures)
+	incCounter("gateway_circuit_breaker_transitions_total", "upstream", b.name, "from", from.String(), "to", to.String())
+	setGauge("gateway_circuit_breaker_state", float64(to), "upstream", b.name)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func halfOpenBreaker(t *testing.T, probes int) *circuitBreaker {
+	t.Helper()
+	b := &circuitBreaker{name: t.Name(), cfg: CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Millisecond,
+		HalfOpenRequests: probes,
+	}}
+	b.record(false)
+	time.Sleep(2 * time.Millisecond)
+	return b
+}
+
+func TestBreakerRetriesDoNotTakeProbes(t *testing.T) {
+	b := halfOpenBreaker(t, 1)
+
+	if ok, _ := b.allowRetry(); ok {
+		t.Fatal("retry allowed while breaker is open")
+	}
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("probe not allowed after open timeout")
+	}
+	if ok, _ := b.allowRetry(); ok {
+		t.Fatal("retry allowed while breaker is half-open")
+	}
+	if state, _ := b.snapshot(); state != breakerHalfOpen || b.probes != 1 {
+		t.Fatalf("got state %s probes %d, want half_open with 1 probe", state, b.probes)
+	}
+
+	b.record(true)
+	if ok, _ := b.allowRetry(); !ok {
+		t.Fatal("retry not allowed after breaker closed")
+	}
+}
+
+func TestBreakerReleaseFreesProbe(t *testing.T) {
+	b := halfOpenBreaker(t, 1)
+
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("probe not allowed after open timeout")
+	}
+	if ok, _ := b.allow(); ok {
+		t.Fatal("second probe allowed with halfOpenRequests=1")
+	}
+
+	b.release()
+	if state, _ := b.snapshot(); state != breakerHalfOpen {
+		t.Fatalf("got state %s after release, want half_open", state)
+	}
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("probe not allowed after release")
+	}
+}
+
+// клиент, ушедший раньше ответа, не должен открывать breaker для всех
+func TestProxyClientCancelIsNotUpstreamFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	pool, err := newUpstreamPool(t.Name(), UpstreamConfig{
+		Instances:      []string{upstream.URL},
+		Balancer:       balancerRoundRobin,
+		Timeout:        5 * time.Second,
+		Retry:          RetryConfig{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		CircuitBreaker: CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenRequests: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.GET("/x", func(c *gin.Context) { proxyRequest(c, pool) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req := httptest.NewRequest(http.MethodGet, "/x", nil).WithContext(ctx)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if state, failures := pool.breaker.snapshot(); state != breakerClosed || failures != 0 {
+		t.Errorf("got breaker %s with %d failures, want closed with 0", state, failures)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("got %d upstream calls, want 1 (no retries for a gone client)", n)
+	}
+}
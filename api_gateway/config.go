@@ -3,6 +3,9 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -10,19 +13,58 @@ import (
 const defaultPort = ":8080"
 
 var (
-	usersServiceURL  string
-	ordersServiceURL string
-	jwtSecretString  string
+	gatewayConfigPath   string
+	configWatchInterval time.Duration
+	jwksURL             string
+	revocationsURL      string
+	revocationInterval  time.Duration
+	internalAuthSecret  []byte
+
+	// проверка API-ключей в service_users и сколько помнить результат
+	apiKeyVerifyURL string
+	apiKeyCacheTTL  time.Duration
+
+	// балансировщики перед шлюзом, которым можно верить в X-Forwarded-For
+	trustedProxies []string
+
+	rateLimitRedisAddr     string
+	rateLimitRedisPassword string
+	rateLimitRedisDB       int
 )
 
 func initConfig() {
 	_ = godotenv.Load()
 
-	usersServiceURL = getenv("USERS_SERVICE_URL", "http://localhost:8081")
-	ordersServiceURL = getenv("ORDERS_SERVICE_URL", "http://localhost:8082")
-	jwtSecretString = getenv("JWT_SECRET", "dev-secret-change-me")
+	gatewayConfigPath = getenv("GATEWAY_CONFIG", "gateway.yaml")
+	// публичные ключи для проверки JWT публикует service_users
+	jwksURL = getenv("JWKS_URL", "http://localhost:8081/.well-known/jwks.json")
+	// общий с сервисами ключ для подписи X-User-ID / X-User-Roles (пусто — не подписываем)
+	internalAuthSecret = []byte(getenv("INTERNAL_AUTH_SECRET", ""))
+
+	// денылист отозванных токенов (service_users)
+	revocationsURL = getenv("REVOCATIONS_URL", "http://localhost:8081/internal/revocations")
+	revocationInterval = getenvDuration("REVOCATION_POLL_INTERVAL", 15*time.Second)
+
+	apiKeyVerifyURL = getenv("API_KEY_VERIFY_URL", "http://localhost:8081/internal/api-keys/verify")
+	apiKeyCacheTTL = getenvDuration("API_KEY_CACHE_TTL", 30*time.Second)
+
+	// общее хранилище лимитов для нескольких экземпляров шлюза (пусто — в памяти)
+	rateLimitRedisAddr = getenv("RATE_LIMIT_REDIS_ADDR", "")
+	rateLimitRedisPassword = getenv("RATE_LIMIT_REDIS_PASSWORD", "")
+	rateLimitRedisDB = getenvInt("RATE_LIMIT_REDIS_DB", 0)
+	initRateLimitStore()
+
+	for _, p := range strings.Split(getenv("GATEWAY_TRUSTED_PROXIES", ""), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			trustedProxies = append(trustedProxies, p)
+		}
+	}
+
+	configWatchInterval = getenvDuration("GATEWAY_CONFIG_WATCH_INTERVAL", 5*time.Second)
 
-	log.Printf("Gateway config: users=%s orders=%s", usersServiceURL, ordersServiceURL)
+	if err := reloadGateway(); err != nil {
+		log.Fatalf("failed to load gateway config: %v", err)
+	}
 }
 
 func getenv(key, def string) string {
@@ -31,3 +73,27 @@ func getenv(key, def string) string {
 	}
 	return def
 }
+
+func getenvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return d
+}
+
+func getenvInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return n
+}
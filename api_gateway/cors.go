@@ -2,17 +2,61 @@ package main
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func CORSMiddleware() gin.HandlerFunc {
+type CORSConfig struct {
+	AllowOrigins  []string `yaml:"allowOrigins"`
+	AllowMethods  []string `yaml:"allowMethods"`
+	AllowHeaders  []string `yaml:"allowHeaders"`
+	ExposeHeaders []string `yaml:"exposeHeaders"`
+}
+
+// значения по умолчанию, если секция cors в конфиге не заполнена
+func (cfg *CORSConfig) applyDefaults() {
+	if len(cfg.AllowOrigins) == 0 {
+		cfg.AllowOrigins = []string{"*"}
+	}
+	if len(cfg.AllowMethods) == 0 {
+		cfg.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	}
+	if len(cfg.AllowHeaders) == 0 {
+		cfg.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-API-Key", "X-Requested-With", "X-Request-ID"}
+	}
+	if len(cfg.ExposeHeaders) == 0 {
+		cfg.ExposeHeaders = []string{"X-Request-ID", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"}
+	}
+}
+
+func CORSMiddleware(cfg CORSConfig) gin.HandlerFunc {
+	anyOrigin := false
+	origins := make(map[string]bool, len(cfg.AllowOrigins))
+	for _, o := range cfg.AllowOrigins {
+		if o == "*" {
+			anyOrigin = true
+		}
+		origins[o] = true
+	}
+
+	methods := strings.Join(cfg.AllowMethods, ",")
+	headers := strings.Join(cfg.AllowHeaders, ",")
+	expose := strings.Join(cfg.ExposeHeaders, ",")
+
 	return func(c *gin.Context) {
 		h := c.Writer.Header()
-		h.Set("Access-Control-Allow-Origin", "*")
-		h.Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-		h.Set("Access-Control-Allow-Headers", "Origin,Content-Type,Accept,Authorization,X-Requested-With,X-Request-ID")
-		h.Set("Access-Control-Expose-Headers", "X-Request-ID")
+		if anyOrigin {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Add("Vary", "Origin")
+			if origin := c.GetHeader("Origin"); origins[origin] {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+		h.Set("Access-Control-Allow-Methods", methods)
+		h.Set("Access-Control-Allow-Headers", headers)
+		h.Set("Access-Control-Expose-Headers", expose)
 
 		if c.Request.Method == http.MethodOptions {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// параметры активной проверки здоровья экземпляров
+type HealthCheckConfig struct {
+	Path               string        `yaml:"path"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	UnhealthyThreshold int           `yaml:"unhealthyThreshold"` // подряд неудачных проб до исключения
+	HealthyThreshold   int           `yaml:"healthyThreshold"`   // подряд удачных проб до возврата
+}
+
+func (hc *HealthCheckConfig) applyDefaults() {
+	if hc.Path == "" {
+		hc.Path = "/healthz"
+	}
+	if hc.Interval <= 0 {
+		hc.Interval = 5 * time.Second
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = 2 * time.Second
+	}
+	if hc.UnhealthyThreshold <= 0 {
+		hc.UnhealthyThreshold = 2
+	}
+	if hc.HealthyThreshold <= 0 {
+		hc.HealthyThreshold = 1
+	}
+}
+
+// шаг планировщика проверок; фактический период задаётся interval каждого upstream
+const healthCheckTick = time.Second
+
+var healthClient = &http.Client{}
+
+// фоновые проверки GET /healthz для всех экземпляров актуального снимка
+func runHealthChecks() {
+	ticker := time.NewTicker(healthCheckTick)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		snap := currentGateway.Load()
+		if snap == nil {
+			continue
+		}
+
+		now := time.Now()
+		for _, pool := range snap.upstreams {
+			for _, inst := range pool.instances {
+				if !inst.checking.CompareAndSwap(false, true) {
+					continue
+				}
+
+				inst.mu.Lock()
+				due := now.Sub(inst.lastCheck) >= pool.health.Interval
+				if due {
+					inst.lastCheck = now
+				}
+				inst.mu.Unlock()
+
+				if due {
+					go probeInstance(pool.name, pool.health, inst)
+				} else {
+					inst.checking.Store(false)
+				}
+			}
+		}
+	}
+}
+
+func probeInstance(pool string, hc HealthCheckConfig, inst *upstreamInstance) {
+	defer inst.checking.Store(false)
+
+	err := checkInstance(hc, inst)
+
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+
+	if err != nil {
+		inst.lastError = err.Error()
+		inst.okStreak = 0
+		inst.failStreak++
+		if inst.healthy.Load() && inst.failStreak >= hc.UnhealthyThreshold {
+			inst.healthy.Store(false)
+			inst.lastChanged = time.Now()
+			setGauge("gateway_upstream_instance_healthy", 0, "upstream", pool, "instance", inst.raw)
+			log.Printf("[gateway] upstream=%s instance=%s ejected: %v", pool, inst.raw, err)
+		}
+		return
+	}
+
+	inst.lastError = ""
+	inst.failStreak = 0
+	inst.okStreak++
+	if !inst.healthy.Load() && inst.okStreak >= hc.HealthyThreshold {
+		inst.healthy.Store(true)
+		inst.lastChanged = time.Now()
+		setGauge("gateway_upstream_instance_healthy", 1, "upstream", pool, "instance", inst.raw)
+		log.Printf("[gateway] upstream=%s instance=%s readmitted", pool, inst.raw)
+	}
+}
+
+func checkInstance(hc HealthCheckConfig, inst *upstreamInstance) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.Timeout)
+	defer cancel()
+
+	target := *inst.url
+	target.Path = hc.Path
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := healthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// GET /admin/upstreams — состояние экземпляров (только admin)
+func handleUpstreamsStatus(c *gin.Context) {
+	snap := currentGateway.Load()
+
+	names := make([]string, 0, len(snap.upstreams))
+	for name := range snap.upstreams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	items := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		pool := snap.upstreams[name]
+
+		instances := make([]gin.H, 0, len(pool.instances))
+		for _, inst := range pool.instances {
+			inst.mu.Lock()
+			instances = append(instances, gin.H{
+				"url":         inst.raw,
+				"healthy":     inst.healthy.Load(),
+				"inFlight":    inst.inFlight.Load(),
+				"lastCheck":   inst.lastCheck,
+				"lastError":   inst.lastError,
+				"lastChanged": inst.lastChanged,
+			})
+			inst.mu.Unlock()
+		}
+
+		state, failures := pool.breaker.snapshot()
+		items = append(items, gin.H{
+			"name":      name,
+			"balancer":  pool.balancer,
+			"instances": instances,
+			"circuit": gin.H{
+				"state":    state.String(),
+				"failures": failures,
+			},
+		})
+	}
+
+	success(c, gin.H{
+		"upstreams":      items,
+		"configLoadedAt": snap.loadedAt,
+	})
+}
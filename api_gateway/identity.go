@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// внутренние заголовки с проверенной личностью пользователя (gateway → сервисы)
+const (
+	headerUserID            = "X-User-ID"
+	headerUserRoles         = "X-User-Roles"
+	headerUserPermissions   = "X-User-Permissions"
+	headerIdentityTimestamp = "X-Identity-Timestamp"
+	headerIdentitySignature = "X-Identity-Signature"
+)
+
+var identityHeaders = []string{
+	headerUserID,
+	headerUserRoles,
+	headerUserPermissions,
+	headerIdentityTimestamp,
+	headerIdentitySignature,
+}
+
+// подпись: HMAC-SHA256(userId \n roles \n permissions \n requestId \n timestamp)
+func signIdentity(secret []byte, userID, roles, permissions, requestID, ts string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(userID + "\n" + roles + "\n" + permissions + "\n" + requestID + "\n" + ts))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// убираем то, что прислал клиент, и подставляем подписанную личность из JWT
+func setIdentityHeaders(c *gin.Context, h http.Header) {
+	for _, name := range identityHeaders {
+		h.Del(name)
+	}
+
+	if len(internalAuthSecret) == 0 {
+		return
+	}
+
+	userIDVal, ok := c.Get("userId")
+	if !ok {
+		return
+	}
+	userID, _ := userIDVal.(string)
+	if userID == "" {
+		return
+	}
+
+	rolesVal, _ := c.Get("roles")
+	roleList, _ := rolesVal.([]string)
+	roles := strings.Join(roleList, ",")
+
+	permsVal, _ := c.Get("permissions")
+	permList, _ := permsVal.([]string)
+	permissions := strings.Join(permList, ",")
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	h.Set(headerUserID, userID)
+	h.Set(headerUserRoles, roles)
+	h.Set(headerUserPermissions, permissions)
+	h.Set(headerIdentityTimestamp, ts)
+	h.Set(headerIdentitySignature, signIdentity(internalAuthSecret, userID, roles, permissions, getRequestID(c), ts))
+}
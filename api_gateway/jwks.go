@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	jwksTTL          = 10 * time.Minute // плановое обновление набора ключей
+	jwksMinRefresh   = 10 * time.Second // не чаще, если пришёл неизвестный kid
+	jwksFetchTimeout = 5 * time.Second
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+}
+
+type verificationKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// кэш публичных ключей service_users (GET /.well-known/jwks.json)
+type jwksCache struct {
+	mu          sync.Mutex
+	keys        map[string]verificationKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	refreshing  chan struct{} // закрывается, когда идущее обновление закончится
+}
+
+var (
+	jwks       = &jwksCache{}
+	jwksClient = &http.Client{Timeout: jwksFetchTimeout}
+)
+
+// HTTP-запрос идёт без блокировки: проверка токенов с известными ключами
+// не ждёт обновления, а неизвестный kid ждёт одного общего запроса
+func (j *jwksCache) lookup(kid string) (verificationKey, bool) {
+	j.mu.Lock()
+	k, ok := j.keys[kid]
+	stale := time.Since(j.fetchedAt) > jwksTTL
+	if (ok && !stale) || (j.refreshing == nil && time.Since(j.lastAttempt) < jwksMinRefresh) {
+		j.mu.Unlock()
+		return k, ok
+	}
+
+	// ключ неизвестен (ротация) или кэш устарел — перечитываем
+	done := j.refreshLocked()
+	j.mu.Unlock()
+	if ok {
+		// устаревший, но известный ключ отдаём сразу, обновление идёт в фоне
+		return k, ok
+	}
+
+	<-done
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	k, ok = j.keys[kid]
+	return k, ok
+}
+
+// запустить обновление, если оно ещё не идёт; вызывается под j.mu
+func (j *jwksCache) refreshLocked() chan struct{} {
+	if j.refreshing != nil {
+		return j.refreshing
+	}
+	done := make(chan struct{})
+	j.refreshing = done
+	j.lastAttempt = time.Now()
+
+	go func() {
+		keys, err := fetchJWKS(jwksURL)
+
+		j.mu.Lock()
+		if err != nil {
+			log.Printf("JWKS refresh from %s failed: %v", jwksURL, err)
+		} else {
+			j.keys = keys
+			j.fetchedAt = time.Now()
+		}
+		j.refreshing = nil
+		j.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+func fetchJWKS(url string) (map[string]verificationKey, error) {
+	resp, err := jwksClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]verificationKey, len(set.Keys))
+	for _, k := range set.Keys {
+		vk, err := parseJWK(k)
+		if err != nil {
+			log.Printf("JWKS: skip key kid=%s: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = vk
+	}
+	return keys, nil
+}
+
+func parseJWK(k jwk) (verificationKey, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+
+	switch {
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := b64(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return verificationKey{}, errors.New("invalid Ed25519 key")
+		}
+		return verificationKey{alg: jwt.SigningMethodEdDSA.Alg(), key: ed25519.PublicKey(x)}, nil
+
+	case k.Kty == "RSA":
+		n, err := b64(k.N)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		e, err := b64(k.E)
+		if err != nil {
+			return verificationKey{}, err
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return verificationKey{alg: jwt.SigningMethodRS256.Alg(), key: pub}, nil
+
+	default:
+		return verificationKey{}, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+// keyfunc для jwt.Parse: ключ по kid, алгоритм должен совпадать с ключом
+func jwksKeyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	k, ok := jwks.lookup(kid)
+	if !ok || token.Method.Alg() != k.alg {
+		return nil, jwt.ErrTokenUnverifiable
+	}
+	return k.key, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// JWKS-сервер с одним ключом kid; release открывает ответы (nil — отвечать сразу)
+func newTestJWKSServer(t *testing.T, kid string, release chan struct{}) *atomic.Int32 {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if release != nil {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jwk{{
+			Kty: "OKP", Crv: "Ed25519", Kid: kid, X: base64.RawURLEncoding.EncodeToString(pub),
+		}}})
+	}))
+	t.Cleanup(srv.Close)
+
+	prev := jwksURL
+	jwksURL = srv.URL
+	t.Cleanup(func() { jwksURL = prev })
+	return &hits
+}
+
+func TestJWKSUnknownKidSharesOneFetch(t *testing.T) {
+	release := make(chan struct{})
+	hits := newTestJWKSServer(t, "k2", release)
+	cache := &jwksCache{}
+
+	var wg sync.WaitGroup
+	found := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, ok := cache.lookup("k2")
+			found <- ok
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(found)
+
+	for ok := range found {
+		if !ok {
+			t.Error("lookup did not find the rotated key")
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("got %d fetches, want 1", n)
+	}
+}
+
+func TestJWKSStaleKeyDoesNotWaitForRefresh(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	hits := newTestJWKSServer(t, "k1", release)
+
+	cache := &jwksCache{
+		keys:      map[string]verificationKey{"k1": {alg: "EdDSA"}},
+		fetchedAt: time.Now().Add(-2 * jwksTTL),
+	}
+
+	// сервер не отвечает, а известный ключ всё равно отдаётся сразу
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, ok := cache.lookup("k1"); !ok {
+			t.Fatal("stale key was not returned")
+		}
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("lookups took %v while refresh was in flight", d)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := hits.Load(); n != 1 {
+		t.Errorf("got %d fetches, want 1", n)
+	}
+}
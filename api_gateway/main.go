@@ -2,49 +2,25 @@ package main
 
 import (
 	"log"
-
-	"github.com/gin-gonic/gin"
+	"net/http"
 )
 
 func main() {
 	initConfig()
 
-	router := gin.New()
-	router.Use(
-		gin.Recovery(),
-		RequestIDMiddleware(),
-		LoggingMiddleware(),
-		CORSMiddleware(),
-		RateLimitMiddleware(),
-	)
-
-	// Публичные маршруты (без JWT)
-	public := router.Group("/v1")
-	{
-		public.POST("/users/register", proxyToUsers)
-		public.POST("/users/login", proxyToUsers)
-	}
-
-	// Защищённые маршруты (с JWT)
-	protected := router.Group("/v1")
-	protected.Use(JWTMiddleware())
-	{
-		// users
-		protected.GET("/users/me", proxyToUsers)
-		protected.PATCH("/users/me", proxyToUsers)
-		protected.GET("/users", proxyToUsers)
+	// маршруты, upstream-ы, CORS и лимиты описаны в gateway.yaml
+	// и перечитываются без рестарта (SIGHUP или изменение файла)
+	go watchGatewayConfig(configWatchInterval)
+	go runHealthChecks()
+	go runRevocationSync(revocationInterval)
 
-		// orders
-		protected.POST("/orders", proxyToOrders)
-		protected.GET("/orders", proxyToOrders)
-		protected.GET("/orders/:id", proxyToOrders)
-		protected.PATCH("/orders/:id/status", proxyToOrders)
-		protected.POST("/orders/:id/cancel", proxyToOrders)
-		protected.DELETE("/orders/:id", proxyToOrders)
+	srv := &http.Server{
+		Addr:    defaultPort,
+		Handler: gatewayHandler(),
 	}
 
 	log.Println("api_gateway listening on", defaultPort)
-	if err := router.Run(defaultPort); err != nil {
+	if err := srv.ListenAndServe(); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// простейшие счётчики/датчики в формате Prometheus (text exposition)
+type metricSeries struct {
+	name   string
+	labels string
+	kind   string
+	value  float64
+}
+
+var (
+	metrics   = make(map[string]*metricSeries)
+	metricsMu sync.Mutex
+)
+
+// labels передаются парами: "upstream", "users", "outcome", "ok"
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func metricFor(kind, name string, labels []string) *metricSeries {
+	l := formatLabels(labels)
+	key := name + l
+
+	m, ok := metrics[key]
+	if !ok {
+		m = &metricSeries{name: name, labels: l, kind: kind}
+		metrics[key] = m
+	}
+	return m
+}
+
+func incCounter(name string, labels ...string) {
+	metricsMu.Lock()
+	metricFor("counter", name, labels).value++
+	metricsMu.Unlock()
+}
+
+func setGauge(name string, value float64, labels ...string) {
+	metricsMu.Lock()
+	metricFor("gauge", name, labels).value = value
+	metricsMu.Unlock()
+}
+
+// убрать датчик (например, удалённого из конфига экземпляра)
+func deleteGauge(name string, labels ...string) {
+	metricsMu.Lock()
+	delete(metrics, name+formatLabels(labels))
+	metricsMu.Unlock()
+}
+
+// GET /metrics
+func handleMetrics(c *gin.Context) {
+	metricsMu.Lock()
+	series := make([]metricSeries, 0, len(metrics))
+	for _, m := range metrics {
+		series = append(series, *m)
+	}
+	metricsMu.Unlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].name != series[j].name {
+			return series[i].name < series[j].name
+		}
+		return series[i].labels < series[j].labels
+	})
+
+	var b strings.Builder
+	for i, m := range series {
+		if i == 0 || series[i-1].name != m.name {
+			fmt.Fprintf(&b, "# TYPE %s %s\n", m.name, m.kind)
+		}
+		fmt.Fprintf(&b, "%s%s %g\n", m.name, m.labels, m.value)
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(b.String()))
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io"
+	"math"
 	"net/http"
-	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 var httpClient = &http.Client{}
 
+// клиент закрыл соединение до ответа (код nginx, в ответ не уходит — только в логи)
+const statusClientClosedRequest = 499
+
 func copyHeaders(dst, src http.Header) {
 	for k, vv := range src {
 		for _, v := range vv {
@@ -18,37 +26,164 @@ func copyHeaders(dst, src http.Header) {
 	}
 }
 
-func proxyRequest(c *gin.Context, targetBase string) {
-	targetURL, err := url.Parse(targetBase)
-	if err != nil {
-		fail(c, http.StatusInternalServerError, "CONFIG_ERROR", "Invalid target URL")
-		return
+// ключ для consistent hashing: id пользователя, для анонимных — IP
+func balanceKey(c *gin.Context) string {
+	if v, ok := c.Get("userId"); ok {
+		if s, ok := v.(string); ok && s != "" {
+			return s
+		}
+	}
+	return c.ClientIP()
+}
+
+// ответы, которые считаются отказом upstream (для повторов и circuit breaker)
+func isUpstreamFailure(status int) bool {
+	return status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+func proxyRequest(c *gin.Context, pool *upstreamPool) {
+	attempts := 1
+	if isIdempotentMethod(c.Request.Method) {
+		attempts = pool.retry.Attempts
+	}
+
+	// для повторов тело нужно прочитать заранее
+	var body []byte
+	if attempts > 1 && c.Request.Body != nil {
+		b, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			fail(c, http.StatusBadRequest, "INVALID_BODY", "Failed to read request body")
+			return
+		}
+		body = b
 	}
 
-	// целевой URL = targetBase + оригинальный путь + query
+	for attempt := 1; ; attempt++ {
+		var ok bool
+		var wait time.Duration
+		if attempt == 1 {
+			ok, wait = pool.breaker.allow()
+		} else {
+			ok, wait = pool.breaker.allowRetry()
+		}
+		if !ok {
+			incCounter("gateway_upstream_requests_total", "upstream", pool.name, "outcome", "rejected")
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			fail(c, http.StatusServiceUnavailable, "UPSTREAM_UNAVAILABLE", "Upstream service is temporarily unavailable")
+			return
+		}
+
+		inst := pool.pick(balanceKey(c))
+		if inst == nil {
+			pool.breaker.record(false)
+			incCounter("gateway_upstream_requests_total", "upstream", pool.name, "outcome", "no_healthy")
+			fail(c, http.StatusServiceUnavailable, "NO_HEALTHY_UPSTREAM", "No healthy upstream instances available")
+			return
+		}
+
+		var reqBody io.Reader = c.Request.Body
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+
+		if !proxyAttempt(c, pool, inst, reqBody, attempt < attempts) {
+			return
+		}
+
+		incCounter("gateway_upstream_retries_total", "upstream", pool.name)
+		select {
+		case <-time.After(retryBackoff(pool.retry, attempt)):
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// одна попытка вызова экземпляра. true — ответ клиенту не отправлен
+// и запрос можно повторить (только если canRetry).
+func proxyAttempt(c *gin.Context, pool *upstreamPool, inst *upstreamInstance, body io.Reader, canRetry bool) bool {
+	inst.inFlight.Add(1)
+	defer inst.inFlight.Add(-1)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), pool.timeout)
+	defer cancel()
+
+	// целевой URL = адрес экземпляра + оригинальный путь + query
+	targetURL := *inst.url
 	targetURL.Path = c.Request.URL.Path
 	targetURL.RawQuery = c.Request.URL.RawQuery
 
-	req, err := http.NewRequest(c.Request.Method, targetURL.String(), c.Request.Body)
+	req, err := http.NewRequestWithContext(ctx, c.Request.Method, targetURL.String(), body)
 	if err != nil {
 		fail(c, http.StatusInternalServerError, "PROXY_ERROR", "Failed to create proxied request")
-		return
+		return false
 	}
 
 	// заголовки пользователя → сервис
 	copyHeaders(req.Header, c.Request.Header)
 
+	// реальный IP клиента (для счётчиков неудачных входов и т.п.);
+	// присланный клиентом X-Forwarded-For учтён в ClientIP только от доверенных прокси
+	req.Header.Set("X-Forwarded-For", c.ClientIP())
+
 	// гарантируем X-Request-ID
 	reqID := getRequestID(c)
 	req.Header.Set("X-Request-ID", reqID)
 
+	// API-ключ дальше шлюза не уходит: сервисам достаточно подписанной личности
+	req.Header.Del("X-API-Key")
+	if _, ok := c.Get("apiKeyId"); ok {
+		req.Header.Del("Authorization")
+	}
+
+	// подписанные X-User-ID / X-User-Roles вместо повторного разбора JWT в сервисах
+	setIdentityHeaders(c, req.Header)
+
 	resp, err := httpClient.Do(req)
+
+	// клиент отключился: upstream тут ни при чём, в breaker не считаем
+	if c.Request.Context().Err() != nil {
+		pool.breaker.release()
+		if resp != nil {
+			resp.Body.Close()
+		}
+		incCounter("gateway_upstream_requests_total", "upstream", pool.name, "outcome", "canceled")
+		c.Status(statusClientClosedRequest)
+		return false
+	}
+
+	failed := err != nil || isUpstreamFailure(resp.StatusCode)
+	pool.breaker.record(!failed)
+
+	if failed && canRetry {
+		if retry, _ := pool.breaker.allowRetry(); retry {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return true
+		}
+	}
+
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			incCounter("gateway_upstream_requests_total", "upstream", pool.name, "outcome", "timeout")
+			fail(c, http.StatusGatewayTimeout, "UPSTREAM_TIMEOUT", "Upstream service did not respond in time")
+			return false
+		}
+		incCounter("gateway_upstream_requests_total", "upstream", pool.name, "outcome", "error")
 		fail(c, http.StatusBadGateway, "UPSTREAM_ERROR", "Failed to call upstream service")
-		return
+		return false
 	}
 	defer resp.Body.Close()
 
+	outcome := "ok"
+	if failed {
+		outcome = "error"
+	}
+	incCounter("gateway_upstream_requests_total", "upstream", pool.name, "outcome", outcome)
+
 	// заголовки от сервиса → клиент
 	for k, vv := range resp.Header {
 		for _, v := range vv {
@@ -58,12 +193,5 @@ func proxyRequest(c *gin.Context, targetBase string) {
 
 	c.Writer.WriteHeader(resp.StatusCode)
 	_, _ = io.Copy(c.Writer, resp.Body)
-}
-
-func proxyToUsers(c *gin.Context) {
-	proxyRequest(c, usersServiceURL)
-}
-
-func proxyToOrders(c *gin.Context) {
-	proxyRequest(c, ordersServiceURL)
+	return false
 }
@@ -1,45 +1,81 @@
 package main
 
 import (
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-type visitor struct {
-	lastSeen time.Time
-	tokens   int
+// хранилище корзин token bucket. Take атомарно пополняет корзину key
+// по прошедшему времени и пытается взять из неё один токен;
+// возвращает, удалось ли, и сколько токенов осталось.
+type RateLimitStore interface {
+	Take(key string, limit RateLimitConfig, now time.Time) (allowed bool, tokens float64, err error)
 }
 
-var (
-	visitors   = make(map[string]*visitor)
-	visitorsMu sync.Mutex
+// значения для класса default, если он не задан в конфиге
+const (
+	rateLimitDefaultRate  = 10 // токенов в секунду
+	rateLimitDefaultBurst = 20 // ёмкость корзины
 )
 
+// класс лимита по IP перед проверкой JWT / API-ключа: иначе запросы с
+// неверными учётными данными отсекаются до подсчёта и не ограничиваются
 const (
-	rateLimitWindow    = time.Second // окно 1 секунда
-	rateLimitMaxTokens = 10          // 10 запросов/сек на IP
+	preAuthRateLimitClass = "preauth"
+	preAuthDefaultRate    = 50
+	preAuthDefaultBurst   = 100
 )
 
-func RateLimitMiddleware() gin.HandlerFunc {
+// хранилище выбирается при старте (RATE_LIMIT_REDIS_ADDR) и не меняется при перезагрузке конфига
+var rateLimitStore RateLimitStore
+
+// ключ лимита: id пользователя после JWT, иначе IP
+func rateLimitKey(c *gin.Context, class string) string {
+	if v, ok := c.Get("userId"); ok {
+		if s, ok := v.(string); ok && s != "" {
+			return class + "|user:" + s
+		}
+	}
+	return class + "|ip:" + c.ClientIP()
+}
+
+func RateLimitMiddleware(class string, limit RateLimitConfig) gin.HandlerFunc {
+	return rateLimit(limit, func(c *gin.Context) string {
+		return rateLimitKey(c, class)
+	})
+}
+
+// лимит только по IP, до аутентификации
+func PreAuthRateLimitMiddleware(limit RateLimitConfig) gin.HandlerFunc {
+	return rateLimit(limit, func(c *gin.Context) string {
+		return preAuthRateLimitClass + "|ip:" + c.ClientIP()
+	})
+}
+
+func rateLimit(limit RateLimitConfig, key func(c *gin.Context) string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ip := c.ClientIP()
-		now := time.Now()
-
-		visitorsMu.Lock()
-		v, ok := visitors[ip]
-		if !ok || now.Sub(v.lastSeen) > rateLimitWindow {
-			v = &visitor{
-				lastSeen: now,
-				tokens:   rateLimitMaxTokens,
-			}
-			visitors[ip] = v
+		allowed, tokens, err := rateLimitStore.Take(key(c), limit, time.Now())
+		if err != nil {
+			// хранилище недоступно — лучше пропустить, чем положить весь трафик
+			log.Printf("[gateway] requestId=%s rate limit store error: %v", getRequestID(c), err)
+			c.Next()
+			return
 		}
 
-		if v.tokens <= 0 {
-			visitorsMu.Unlock()
+		h := c.Writer.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(int(math.Floor(tokens))))
+		// через сколько секунд корзина снова будет полной
+		h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil((float64(limit.Burst)-tokens)/limit.Rate))))
+
+		if !allowed {
+			h.Set("Retry-After", strconv.Itoa(int(math.Ceil((1-tokens)/limit.Rate))))
 			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
 				"success": false,
 				"error": gin.H{
@@ -50,10 +86,76 @@ func RateLimitMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		v.tokens--
-		v.lastSeen = now
-		visitorsMu.Unlock()
-
 		c.Next()
 	}
 }
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+	full     time.Duration // за сколько пустая корзина наполняется целиком
+}
+
+// in-memory хранилище для одного экземпляра шлюза
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *memoryRateLimitStore) Take(key string, limit RateLimitConfig, now time.Time) (bool, float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), lastSeen: now}
+		s.buckets[key] = b
+	}
+	b.full = time.Duration(float64(limit.Burst) / limit.Rate * float64(time.Second))
+
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+	}
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		return false, b.tokens, nil
+	}
+	b.tokens--
+	return true, b.tokens, nil
+}
+
+// удаляем корзины, которые за время простоя уже наполнились:
+// для них отсутствие записи и полная корзина — одно и то же
+func (s *memoryRateLimitStore) evictIdle(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.mu.Lock()
+		for key, b := range s.buckets {
+			if now.Sub(b.lastSeen) > b.full {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func initRateLimitStore() {
+	if rateLimitRedisAddr != "" {
+		rateLimitStore = newRedisRateLimitStore(rateLimitRedisAddr, rateLimitRedisPassword, rateLimitRedisDB)
+		log.Println("Rate limit store: redis at", rateLimitRedisAddr)
+		return
+	}
+
+	mem := newMemoryRateLimitStore()
+	go mem.evictIdle(time.Minute)
+	rateLimitStore = mem
+	log.Println("Rate limit store: in-memory")
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// token bucket в Redis: пополнение и списание одним Lua-скриптом, поэтому
+// несколько экземпляров шлюза делят одни и те же корзины без гонок.
+// Время передаёт шлюз (мс), ключ живёт, пока корзина не наполнится.
+const rateLimitScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1])
+local ts = tonumber(b[2])
+if tokens == nil or ts == nil then
+  tokens = burst
+  ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`
+
+const (
+	redisKeyPrefix   = "gateway:ratelimit:"
+	redisPoolSize    = 16
+	redisDialTimeout = 2 * time.Second
+	redisIOTimeout   = time.Second
+)
+
+var rateLimitScriptSHA = func() string {
+	sum := sha1.Sum([]byte(rateLimitScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// хранилище поверх любого сервера с протоколом RESP (Redis, Valkey, KeyDB)
+type redisRateLimitStore struct {
+	addr     string
+	password string
+	db       int
+	idle     chan *redisConn
+}
+
+func newRedisRateLimitStore(addr, password string, db int) *redisRateLimitStore {
+	return &redisRateLimitStore{
+		addr:     addr,
+		password: password,
+		db:       db,
+		idle:     make(chan *redisConn, redisPoolSize),
+	}
+}
+
+func (s *redisRateLimitStore) Take(key string, limit RateLimitConfig, now time.Time) (bool, float64, error) {
+	args := []string{
+		"1", redisKeyPrefix + key,
+		strconv.FormatFloat(limit.Rate, 'f', -1, 64),
+		strconv.Itoa(limit.Burst),
+		strconv.FormatInt(now.UnixMilli(), 10),
+	}
+
+	reply, err := s.do(append([]string{"EVALSHA", rateLimitScriptSHA}, args...)...)
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		// скрипт ещё не закэширован на сервере (или сервер перезапускался)
+		reply, err = s.do(append([]string{"EVAL", rateLimitScript}, args...)...)
+	}
+	if err != nil {
+		return false, 0, err
+	}
+
+	arr, ok := reply.([]any)
+	if !ok || len(arr) != 2 {
+		return false, 0, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	allowed, _ := arr[0].(int64)
+	raw, _ := arr[1].(string)
+	tokens, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return false, 0, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	return allowed == 1, tokens, nil
+}
+
+// выполнить команду на соединении из пула. Ошибка сервера (-ERR ...)
+// возвращается как есть, соединение при этом остаётся рабочим.
+func (s *redisRateLimitStore) do(args ...string) (any, error) {
+	conn, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(args...)
+	var serverErr redisError
+	if err != nil && !errors.As(err, &serverErr) {
+		conn.Close()
+		return nil, err
+	}
+
+	select {
+	case s.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return reply, err
+}
+
+func (s *redisRateLimitStore) get() (*redisConn, error) {
+	select {
+	case conn := <-s.idle:
+		return conn, nil
+	default:
+	}
+
+	nc, err := net.DialTimeout("tcp", s.addr, redisDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+
+	if s.password != "" {
+		if _, err := conn.do("AUTH", s.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.db != 0 {
+		if _, err := conn.do("SELECT", strconv.Itoa(s.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// ошибка, которую вернул сам сервер
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *redisConn) do(args ...string) (any, error) {
+	if err := c.SetDeadline(time.Now().Add(redisIOTimeout)); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c.Conn, b.String()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+func (c *redisConn) readReply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		arr := make([]any, n)
+		for i := range arr {
+			// ошибки внутри массива (например, из скрипта) не ломают протокол
+			v, err := c.readReply()
+			var serverErr redisError
+			if err != nil && !errors.As(err, &serverErr) {
+				return nil, err
+			}
+			arr[i] = v
+		}
+		return arr, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Стенд для redisRateLimitStore: RESP-сервер в процессе. Понимает AUTH, SELECT,
+// EVALSHA и EVAL нашего скрипта; сам скрипт повторён на Go по тем же правилам.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu       sync.Mutex
+	scripts  map[string]bool // загруженные через EVAL (для EVALSHA)
+	buckets  map[string][2]float64
+	commands []string
+	conns    int
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		ln:       ln,
+		password: password,
+		scripts:  make(map[string]bool),
+		buckets:  make(map[string][2]float64),
+	}
+	t.Cleanup(func() { ln.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeRedis) seen() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		nc, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns++
+		f.mu.Unlock()
+		go f.handle(nc)
+	}
+}
+
+func (f *fakeRedis) handle(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	authed := f.password == ""
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+
+		f.mu.Lock()
+		f.commands = append(f.commands, cmd)
+		var reply string
+		switch {
+		case cmd == "AUTH":
+			if len(args) == 2 && args[1] == f.password {
+				authed = true
+				reply = "+OK\r\n"
+			} else {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case cmd == "SELECT":
+			reply = "+OK\r\n"
+		case cmd == "EVALSHA" && !f.scripts[args[1]]:
+			reply = "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+		case cmd == "EVALSHA" || cmd == "EVAL":
+			if cmd == "EVAL" {
+				if args[1] != rateLimitScript {
+					reply = "-ERR unknown script\r\n"
+					break
+				}
+				f.scripts[rateLimitScriptSHA] = true
+			}
+			reply = f.takeLocked(args[3], args[4], args[5], args[6])
+		default:
+			reply = "-ERR unknown command '" + cmd + "'\r\n"
+		}
+		f.mu.Unlock()
+
+		if _, err := io.WriteString(nc, reply); err != nil {
+			return
+		}
+	}
+}
+
+// rateLimitScript на Go
+func (f *fakeRedis) takeLocked(key, rateArg, burstArg, nowArg string) string {
+	rate, _ := strconv.ParseFloat(rateArg, 64)
+	burst, _ := strconv.ParseFloat(burstArg, 64)
+	now, _ := strconv.ParseFloat(nowArg, 64)
+
+	tokens, ts := burst, now
+	if b, ok := f.buckets[key]; ok {
+		tokens, ts = b[0], b[1]
+	}
+	tokens = math.Min(burst, tokens+math.Max(0, now-ts)/1000*rate)
+	allowed := 0
+	if tokens >= 1 {
+		tokens--
+		allowed = 1
+	}
+	f.buckets[key] = [2]float64{tokens, now}
+
+	s := strconv.FormatFloat(tokens, 'f', -1, 64)
+	return fmt.Sprintf("*2\r\n:%d\r\n$%d\r\n%s\r\n", allowed, len(s), s)
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("expected array, got %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// общее поведение для всех реализаций RateLimitStore
+func TestRateLimitStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) RateLimitStore{
+		"memory": func(t *testing.T) RateLimitStore {
+			return newMemoryRateLimitStore()
+		},
+		"redis": func(t *testing.T) RateLimitStore {
+			return newRedisRateLimitStore(newFakeRedis(t, "").addr(), "", 0)
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			limit := RateLimitConfig{Rate: 2, Burst: 3}
+			start := time.Unix(1700000000, 0)
+
+			take := func(s RateLimitStore, key string, at time.Time, wantAllowed bool, wantTokens float64) {
+				t.Helper()
+				allowed, tokens, err := s.Take(key, limit, at)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if allowed != wantAllowed || math.Abs(tokens-wantTokens) > 1e-9 {
+					t.Fatalf("%s at +%v: got allowed=%v tokens=%v, want allowed=%v tokens=%v",
+						key, at.Sub(start), allowed, tokens, wantAllowed, wantTokens)
+				}
+			}
+
+			t.Run("burst then deny", func(t *testing.T) {
+				s := newStore(t)
+				take(s, "a", start, true, 2)
+				take(s, "a", start, true, 1)
+				take(s, "a", start, true, 0)
+				take(s, "a", start, false, 0)
+			})
+
+			t.Run("refill by elapsed time", func(t *testing.T) {
+				s := newStore(t)
+				for i := 0; i < 3; i++ {
+					take(s, "a", start, true, float64(2-i))
+				}
+				// 250мс при 2 токенах/с — полтокена, мало
+				take(s, "a", start.Add(250*time.Millisecond), false, 0.5)
+				take(s, "a", start.Add(500*time.Millisecond), true, 0)
+				// за 10с корзина наполняется, но не больше burst
+				take(s, "a", start.Add(10*time.Second), true, 2)
+			})
+
+			t.Run("keys are independent", func(t *testing.T) {
+				s := newStore(t)
+				for i := 0; i < 3; i++ {
+					take(s, "a", start, true, float64(2-i))
+				}
+				take(s, "a", start, false, 0)
+				take(s, "b", start, true, 2)
+			})
+
+			t.Run("clock going back does not add tokens", func(t *testing.T) {
+				s := newStore(t)
+				take(s, "a", start, true, 2)
+				take(s, "a", start.Add(-time.Second), true, 1)
+			})
+		})
+	}
+}
+
+func TestRedisRateLimitStoreLoadsScriptOnce(t *testing.T) {
+	f := newFakeRedis(t, "")
+	s := newRedisRateLimitStore(f.addr(), "", 0)
+	limit := RateLimitConfig{Rate: 1, Burst: 10}
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := s.Take("k", limit, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// первый EVALSHA получает NOSCRIPT, дальше скрипт уже в кэше сервера;
+	// ошибка сервера не закрывает соединение
+	want := "EVALSHA EVAL EVALSHA EVALSHA"
+	if got := strings.Join(f.seen(), " "); got != want {
+		t.Errorf("got commands %q, want %q", got, want)
+	}
+	f.mu.Lock()
+	conns := f.conns
+	f.mu.Unlock()
+	if conns != 1 {
+		t.Errorf("got %d connections, want 1", conns)
+	}
+}
+
+func TestRedisRateLimitStoreAuthAndSelect(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+	limit := RateLimitConfig{Rate: 1, Burst: 10}
+
+	s := newRedisRateLimitStore(f.addr(), "secret", 2)
+	if allowed, _, err := s.Take("k", limit, time.Now()); err != nil || !allowed {
+		t.Fatalf("got allowed=%v err=%v, want allowed", allowed, err)
+	}
+	if got := f.seen(); len(got) < 2 || got[0] != "AUTH" || got[1] != "SELECT" {
+		t.Errorf("got commands %v, want AUTH and SELECT first", got)
+	}
+
+	bad := newRedisRateLimitStore(f.addr(), "wrong", 0)
+	if _, _, err := bad.Take("k", limit, time.Now()); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("got err %v, want WRONGPASS", err)
+	}
+}
+
+func TestRedisRateLimitStoreUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	s := newRedisRateLimitStore(addr, "", 0)
+	if _, _, err := s.Take("k", RateLimitConfig{Rate: 1, Burst: 1}, time.Now()); err == nil {
+		t.Error("got nil error for unreachable server")
+	}
+}
+
+// запросы с неверным токеном отсекаются аутентификацией, но лимит по IP до неё
+// их всё равно считает
+func TestPreAuthRateLimitCountsInvalidTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rateLimitStore = newMemoryRateLimitStore()
+
+	cfg, err := parseGatewayConfig("test", []byte(`
+upstreams:
+  orders:
+    url: http://127.0.0.1:1
+rateLimits:
+  preauth:
+    rate: 0.001
+    burst: 2
+routes:
+  - path: /v1/orders
+    methods: [GET]
+    upstream: orders
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	registerRoutes(router, cfg, nil)
+
+	want := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}
+	for i, code := range want {
+		req := httptest.NewRequest(http.MethodGet, "/v1/orders", nil)
+		req.Header.Set("Authorization", "Bearer not-a-jwt")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != code {
+			t.Fatalf("request #%d: got %d, want %d", i+1, w.Code, code)
+		}
+	}
+}
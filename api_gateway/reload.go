@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// снимок конфигурации: таблица маршрутов + собранный под неё gin.Engine.
+// Запрос целиком обрабатывается тем снимком, который был актуален на момент
+// его начала, поэтому перезагрузка не трогает запросы "в полёте".
+type gatewaySnapshot struct {
+	config    *GatewayConfig
+	upstreams map[string]*upstreamPool
+	engine    *gin.Engine
+	loadedAt  time.Time
+}
+
+var (
+	currentGateway atomic.Pointer[gatewaySnapshot]
+
+	reloadMu sync.Mutex
+	// контрольная сумма последней попытки загрузки (в т.ч. неудачной),
+	// чтобы не повторять ошибку на каждом тике
+	lastConfigSum [sha256.Size]byte
+)
+
+func buildGatewayEngine(cfg *GatewayConfig, pools map[string]*upstreamPool) (engine *gin.Engine, err error) {
+	// gin паникует на конфликтующих шаблонах путей — превращаем в ошибку,
+	// чтобы кривой конфиг не уронил работающий шлюз
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("build routes: %v", r)
+		}
+	}()
+
+	engine = gin.New()
+	// шлюз — точка входа: X-Forwarded-For принимаем только от известных прокси
+	if err := engine.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+	engine.Use(
+		gin.Recovery(),
+		RequestIDMiddleware(),
+		LoggingMiddleware(),
+		CORSMiddleware(cfg.CORS),
+	)
+
+	engine.GET("/metrics", handleMetrics)
+	engine.POST("/internal/revocations/notify", InternalRequired(), handleRevocationNotify)
+	engine.GET("/admin/upstreams", JWTMiddleware(), PermissionsRequired("gateway:manage"), handleUpstreamsStatus)
+
+	registerRoutes(engine, cfg, pools)
+	return engine, nil
+}
+
+// перечитать конфиг и атомарно подменить снимок; при ошибке остаётся старый
+func reloadGateway() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	data, err := os.ReadFile(gatewayConfigPath)
+	if err != nil {
+		return err
+	}
+	lastConfigSum = sha256.Sum256(data)
+
+	cfg, err := parseGatewayConfig(gatewayConfigPath, data)
+	if err != nil {
+		return err
+	}
+
+	pools, err := buildUpstreamPools(cfg)
+	if err != nil {
+		return err
+	}
+
+	engine, err := buildGatewayEngine(cfg, pools)
+	if err != nil {
+		return err
+	}
+
+	currentGateway.Store(&gatewaySnapshot{
+		config:    cfg,
+		upstreams: pools,
+		engine:    engine,
+		loadedAt:  time.Now(),
+	})
+
+	// состояние убранных из конфига upstream-ов больше не нужно; запросы,
+	// ещё идущие по старому снимку, держат свои пулы сами
+	pruneInstanceRegistry(pools)
+	pruneBreakers(pools)
+
+	for name, up := range cfg.Upstreams {
+		log.Printf("Gateway config: upstream %s=%s (%s)", name, strings.Join(up.Instances, ","), up.Balancer)
+	}
+	log.Printf("Gateway config: %d routes loaded from %s", len(cfg.Routes), gatewayConfigPath)
+	return nil
+}
+
+// изменился ли файл с момента последней попытки загрузки
+func gatewayConfigChanged() bool {
+	data, err := os.ReadFile(gatewayConfigPath)
+	if err != nil {
+		return false
+	}
+	sum := sha256.Sum256(data)
+
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	return sum != lastConfigSum
+}
+
+// перезагрузка по SIGHUP и по изменению файла (опрос раз в interval, 0 — выключено)
+func watchGatewayConfig(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-hup:
+			log.Println("Gateway config: SIGHUP received, reloading")
+		case <-tick:
+			if !gatewayConfigChanged() {
+				continue
+			}
+			log.Println("Gateway config: file changed, reloading")
+		}
+
+		if err := reloadGateway(); err != nil {
+			log.Printf("Gateway config: reload failed, keeping previous config: %v", err)
+		}
+	}
+}
+
+// http.Handler, который отдаёт запрос актуальному снимку
+func gatewayHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currentGateway.Load().engine.ServeHTTP(w, r)
+	})
+}
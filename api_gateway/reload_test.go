@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadPrunesRemovedUpstreams(t *testing.T) {
+	prev := gatewayConfigPath
+	gatewayConfigPath = filepath.Join(t.TempDir(), "gateway.yaml")
+	t.Cleanup(func() { gatewayConfigPath = prev })
+	load := func(cfg string) {
+		t.Helper()
+		if err := os.WriteFile(gatewayConfigPath, []byte(cfg), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := reloadGateway(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	load(`
+upstreams:
+  users:
+    instances: [http://users-a:8081, http://users-b:8081]
+  legacy:
+    url: http://legacy:9000
+routes: []
+`)
+	load(`
+upstreams:
+  users:
+    instances: [http://users-a:8081]
+routes: []
+`)
+
+	instanceRegistryMu.Lock()
+	_, keptA := instanceRegistry["users|http://users-a:8081"]
+	_, keptB := instanceRegistry["users|http://users-b:8081"]
+	_, keptLegacy := instanceRegistry["legacy|http://legacy:9000"]
+	instanceRegistryMu.Unlock()
+	if !keptA || keptB || keptLegacy {
+		t.Errorf("instances: users-a=%v users-b=%v legacy=%v, want only users-a", keptA, keptB, keptLegacy)
+	}
+
+	breakersMu.Lock()
+	_, keptUsers := breakers["users"]
+	_, keptLegacyBreaker := breakers["legacy"]
+	breakersMu.Unlock()
+	if !keptUsers || keptLegacyBreaker {
+		t.Errorf("breakers: users=%v legacy=%v, want only users", keptUsers, keptLegacyBreaker)
+	}
+
+	metricsMu.Lock()
+	_, gauge := metrics[`gateway_circuit_breaker_state{upstream="legacy"}`]
+	metricsMu.Unlock()
+	if gauge {
+		t.Error("breaker gauge of the removed upstream is still exported")
+	}
+}
@@ -1,9 +1,18 @@
 package main
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
 )
 
+func success(c *gin.Context, data any) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
 func fail(c *gin.Context, status int, code, message string) {
 	c.JSON(status, gin.H{
 		"success": false,
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+)
+
+// описание одного upstream-сервиса
+type UpstreamConfig struct {
+	URL         string            `yaml:"url"`       // сокращённая форма для одного экземпляра
+	Instances   []string          `yaml:"instances"` // адреса реплик
+	Balancer    string            `yaml:"balancer"`  // round_robin / least_in_flight / consistent_hash
+	HealthCheck HealthCheckConfig `yaml:"healthCheck"`
+
+	Timeout        time.Duration        `yaml:"timeout"` // на одну попытку
+	Retry          RetryConfig          `yaml:"retry"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuitBreaker"`
+}
+
+// класс лимита запросов (token bucket)
+type RateLimitConfig struct {
+	Rate  float64 `yaml:"rate"`  // пополнение, токенов в секунду
+	Burst int     `yaml:"burst"` // ёмкость корзины — сколько запросов можно сделать разом
+}
+
+// описание одного маршрута шлюза
+type RouteConfig struct {
+	Path        string   `yaml:"path"`        // шаблон пути в синтаксисе gin: /v1/orders/:id
+	Methods     []string `yaml:"methods"`     // GET / POST / ...
+	Upstream    string   `yaml:"upstream"`    // имя из секции upstreams
+	Auth        *bool    `yaml:"auth"`        // требуется ли JWT (по умолчанию да)
+	Roles       []string `yaml:"roles"`       // нужна хотя бы одна из ролей
+	Permissions []string `yaml:"permissions"` // нужны все перечисленные права
+	RateLimit   string   `yaml:"rateLimit"`   // класс лимита (по умолчанию default)
+	APIKeys     bool     `yaml:"apiKeys"`     // принимать API-ключ вместо JWT
+}
+
+type GatewayConfig struct {
+	Upstreams  map[string]UpstreamConfig  `yaml:"upstreams"`
+	RateLimits map[string]RateLimitConfig `yaml:"rateLimits"`
+	CORS       CORSConfig                 `yaml:"cors"`
+	Routes     []RouteConfig              `yaml:"routes"`
+}
+
+const (
+	defaultRateLimitClass  = "default"
+	defaultUpstreamTimeout = 10 * time.Second
+)
+
+var allowedMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+	http.MethodHead:   true,
+}
+
+func (r RouteConfig) authRequired() bool {
+	return r.Auth == nil || *r.Auth
+}
+
+func (r RouteConfig) rateLimitClass() string {
+	if r.RateLimit == "" {
+		return defaultRateLimitClass
+	}
+	return r.RateLimit
+}
+
+// разбираем YAML/JSON с маршрутами (path нужен только для сообщений об ошибках)
+func parseGatewayConfig(path string, data []byte) (*GatewayConfig, error) {
+	var cfg GatewayConfig
+	if err := yaml.UnmarshalWithOptions(data, &cfg, yaml.DisallowUnknownField()); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	for name, up := range cfg.Upstreams {
+		if up.URL != "" {
+			up.Instances = append([]string{up.URL}, up.Instances...)
+			up.URL = ""
+		}
+		// адреса сервисов можно переопределить из окружения:
+		// USERS_SERVICE_URL=http://a:8081,http://b:8081 и т.п.
+		if v := getenv(strings.ToUpper(name)+"_SERVICE_URL", ""); v != "" {
+			up.Instances = splitInstances(v)
+		}
+		if up.Balancer == "" {
+			up.Balancer = balancerRoundRobin
+		}
+		if up.Timeout <= 0 {
+			up.Timeout = defaultUpstreamTimeout
+		}
+		up.HealthCheck.applyDefaults()
+		up.Retry.applyDefaults()
+		up.CircuitBreaker.applyDefaults()
+		cfg.Upstreams[name] = up
+	}
+
+	if cfg.RateLimits == nil {
+		cfg.RateLimits = make(map[string]RateLimitConfig)
+	}
+	if _, ok := cfg.RateLimits[defaultRateLimitClass]; !ok {
+		cfg.RateLimits[defaultRateLimitClass] = RateLimitConfig{
+			Rate:  rateLimitDefaultRate,
+			Burst: rateLimitDefaultBurst,
+		}
+	}
+	if _, ok := cfg.RateLimits[preAuthRateLimitClass]; !ok {
+		cfg.RateLimits[preAuthRateLimitClass] = RateLimitConfig{
+			Rate:  preAuthDefaultRate,
+			Burst: preAuthDefaultBurst,
+		}
+	}
+
+	cfg.CORS.applyDefaults()
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+func (cfg *GatewayConfig) validate() error {
+	for name, up := range cfg.Upstreams {
+		if len(up.Instances) == 0 {
+			return fmt.Errorf("upstream %q: url or instances is required", name)
+		}
+		switch up.Balancer {
+		case balancerRoundRobin, balancerLeastInFlight, balancerConsistentHash:
+		default:
+			return fmt.Errorf("upstream %q: unknown balancer %q", name, up.Balancer)
+		}
+	}
+
+	for name, rl := range cfg.RateLimits {
+		if rl.Rate <= 0 || rl.Burst <= 0 {
+			return fmt.Errorf("rate limit %q: rate and burst must be > 0", name)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, r := range cfg.Routes {
+		if !strings.HasPrefix(r.Path, "/") {
+			return fmt.Errorf("route #%d: path must start with /", i)
+		}
+		if len(r.Methods) == 0 {
+			return fmt.Errorf("route %s: at least one method is required", r.Path)
+		}
+		if _, ok := cfg.Upstreams[r.Upstream]; !ok {
+			return fmt.Errorf("route %s: unknown upstream %q", r.Path, r.Upstream)
+		}
+		if _, ok := cfg.RateLimits[r.rateLimitClass()]; !ok {
+			return fmt.Errorf("route %s: unknown rate limit class %q", r.Path, r.RateLimit)
+		}
+		if (len(r.Roles) > 0 || len(r.Permissions) > 0) && !r.authRequired() {
+			return fmt.Errorf("route %s: roles and permissions require auth", r.Path)
+		}
+		if r.APIKeys && !r.authRequired() {
+			return fmt.Errorf("route %s: apiKeys requires auth", r.Path)
+		}
+
+		for j, m := range r.Methods {
+			m = strings.ToUpper(m)
+			if !allowedMethods[m] {
+				return fmt.Errorf("route %s: unsupported method %q", r.Path, m)
+			}
+			key := m + " " + r.Path
+			if seen[key] {
+				return fmt.Errorf("route %s: duplicate method %s", r.Path, m)
+			}
+			seen[key] = true
+			cfg.Routes[i].Methods[j] = m
+		}
+	}
+	return nil
+}
+
+// превращаем таблицу маршрутов в gin-маршруты
+func registerRoutes(router gin.IRouter, cfg *GatewayConfig, pools map[string]*upstreamPool) {
+	limiters := make(map[string]gin.HandlerFunc, len(cfg.RateLimits))
+	for name, rl := range cfg.RateLimits {
+		limiters[name] = RateLimitMiddleware(name, rl)
+	}
+	preAuth := PreAuthRateLimitMiddleware(cfg.RateLimits[preAuthRateLimitClass])
+
+	for _, r := range cfg.Routes {
+		pool := pools[r.Upstream]
+
+		// на защищённых маршрутах лимит класса считаем после JWT — по id
+		// пользователя, а до проверки токена — общий лимит по IP
+		var handlers []gin.HandlerFunc
+		switch {
+		case r.APIKeys:
+			handlers = append(handlers, preAuth, APIKeyOrJWTMiddleware())
+		case r.authRequired():
+			handlers = append(handlers, preAuth, JWTMiddleware())
+		}
+		handlers = append(handlers, limiters[r.rateLimitClass()])
+		if len(r.Roles) > 0 {
+			handlers = append(handlers, RolesRequired(r.Roles...))
+		}
+		if len(r.Permissions) > 0 {
+			handlers = append(handlers, PermissionsRequired(r.Permissions...))
+		}
+		handlers = append(handlers, func(c *gin.Context) {
+			proxyRequest(c, pool)
+		})
+
+		for _, m := range r.Methods {
+			router.Handle(m, r.Path, handlers...)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// стратегии балансировки
+const (
+	balancerRoundRobin     = "round_robin"
+	balancerLeastInFlight  = "least_in_flight"
+	balancerConsistentHash = "consistent_hash"
+)
+
+// виртуальных точек на экземпляр в кольце consistent hashing
+const hashRingReplicas = 100
+
+// один экземпляр upstream-сервиса. Состояние (health, in-flight) живёт
+// в реестре и переживает перезагрузку конфига, если URL не поменялся.
+type upstreamInstance struct {
+	url *url.URL
+	raw string
+
+	healthy  atomic.Bool
+	inFlight atomic.Int64
+	checking atomic.Bool
+
+	mu          sync.Mutex
+	failStreak  int
+	okStreak    int
+	lastCheck   time.Time
+	lastError   string
+	lastChanged time.Time
+}
+
+type hashPoint struct {
+	hash     uint32
+	instance *upstreamInstance
+}
+
+// пул экземпляров одного upstream с выбранной стратегией балансировки
+type upstreamPool struct {
+	name      string
+	balancer  string
+	health    HealthCheckConfig
+	timeout   time.Duration
+	retry     RetryConfig
+	breaker   *circuitBreaker
+	instances []*upstreamInstance
+	ring      []hashPoint
+	next      atomic.Uint64
+}
+
+var (
+	instanceRegistry   = make(map[string]*upstreamInstance)
+	instanceRegistryMu sync.Mutex
+)
+
+// взять экземпляр из реестра или создать новый (изначально считается здоровым)
+func registryInstance(pool, rawURL string) (*upstreamInstance, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("url %q must include scheme and host", rawURL)
+	}
+
+	key := pool + "|" + rawURL
+
+	instanceRegistryMu.Lock()
+	defer instanceRegistryMu.Unlock()
+
+	if inst, ok := instanceRegistry[key]; ok {
+		return inst, nil
+	}
+
+	inst := &upstreamInstance{url: u, raw: rawURL, lastChanged: time.Now()}
+	inst.healthy.Store(true)
+	instanceRegistry[key] = inst
+	setGauge("gateway_upstream_instance_healthy", 1, "upstream", pool, "instance", rawURL)
+	return inst, nil
+}
+
+// забыть экземпляры, которых нет в pools (upstream или адрес убрали из конфига)
+func pruneInstanceRegistry(pools map[string]*upstreamPool) {
+	keep := make(map[string]bool)
+	for name, p := range pools {
+		for _, inst := range p.instances {
+			keep[name+"|"+inst.raw] = true
+		}
+	}
+
+	instanceRegistryMu.Lock()
+	defer instanceRegistryMu.Unlock()
+
+	for key, inst := range instanceRegistry {
+		if keep[key] {
+			continue
+		}
+		delete(instanceRegistry, key)
+		pool, _, _ := strings.Cut(key, "|")
+		deleteGauge("gateway_upstream_instance_healthy", "upstream", pool, "instance", inst.raw)
+	}
+}
+
+func newUpstreamPool(name string, cfg UpstreamConfig) (*upstreamPool, error) {
+	p := &upstreamPool{
+		name:     name,
+		balancer: cfg.Balancer,
+		health:   cfg.HealthCheck,
+		timeout:  cfg.Timeout,
+		retry:    cfg.Retry,
+		breaker:  registryBreaker(name, cfg.CircuitBreaker),
+	}
+
+	for _, raw := range cfg.Instances {
+		inst, err := registryInstance(name, raw)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %q: %w", name, err)
+		}
+		p.instances = append(p.instances, inst)
+	}
+
+	if p.balancer == balancerConsistentHash {
+		for _, inst := range p.instances {
+			for i := 0; i < hashRingReplicas; i++ {
+				p.ring = append(p.ring, hashPoint{
+					hash:     crc32.ChecksumIEEE([]byte(inst.raw + "#" + strconv.Itoa(i))),
+					instance: inst,
+				})
+			}
+		}
+		sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	}
+
+	return p, nil
+}
+
+func buildUpstreamPools(cfg *GatewayConfig) (map[string]*upstreamPool, error) {
+	pools := make(map[string]*upstreamPool, len(cfg.Upstreams))
+	for name, up := range cfg.Upstreams {
+		p, err := newUpstreamPool(name, up)
+		if err != nil {
+			return nil, err
+		}
+		pools[name] = p
+	}
+	return pools, nil
+}
+
+// выбрать здоровый экземпляр; key используется только для consistent hashing
+// (id пользователя или IP). nil — здоровых экземпляров нет.
+func (p *upstreamPool) pick(key string) *upstreamInstance {
+	switch p.balancer {
+	case balancerLeastInFlight:
+		var best *upstreamInstance
+		for _, inst := range p.instances {
+			if !inst.healthy.Load() {
+				continue
+			}
+			if best == nil || inst.inFlight.Load() < best.inFlight.Load() {
+				best = inst
+			}
+		}
+		return best
+
+	case balancerConsistentHash:
+		if len(p.ring) == 0 {
+			return nil
+		}
+		h := crc32.ChecksumIEEE([]byte(key))
+		start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+		for i := 0; i < len(p.ring); i++ {
+			inst := p.ring[(start+i)%len(p.ring)].instance
+			if inst.healthy.Load() {
+				return inst
+			}
+		}
+		return nil
+
+	default: // round_robin
+		n := len(p.instances)
+		start := int(p.next.Add(1) % uint64(n))
+		for i := 0; i < n; i++ {
+			inst := p.instances[(start+i)%n]
+			if inst.healthy.Load() {
+				return inst
+			}
+		}
+		return nil
+	}
+}
+
+// разобрать список экземпляров из переменной окружения: "http://a:1,http://b:2"
+func splitInstances(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -11,21 +11,18 @@ import (
 type UserClaims struct {
 	UserID string   `json:"userId"`
 	Roles  []string `json:"roles"`
+	// права ролей на момент выпуска токена, например orders:update:any
+	Permissions []string `json:"permissions"`
+	SessionID   string   `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-var jwtSecret = []byte(jwtSecretString)
-
 func parseToken(tokenString string) (*UserClaims, error) {
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&UserClaims{},
-		func(token *jwt.Token) (interface{}, error) {
-			if token.Method != jwt.SigningMethodHS256 {
-				return nil, jwt.ErrTokenMalformed
-			}
-			return jwtSecret, nil
-		},
+		jwksKeyfunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}),
 	)
 	if err != nil {
 		return nil, err
@@ -39,8 +36,25 @@ func parseToken(tokenString string) (*UserClaims, error) {
 	return claims, nil
 }
 
+// принимает либо подписанные шлюзом X-User-ID / X-User-Roles, либо Bearer-токен
 func AuthRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if hasIdentityHeaders(c) {
+			userID, roles, permissions, err := verifyIdentityHeaders(c)
+			if err != nil {
+				fail(c, http.StatusUnauthorized, "INVALID_IDENTITY", "Identity headers are invalid or expired")
+				c.Abort()
+				return
+			}
+
+			c.Set("userId", userID)
+			c.Set("roles", roles)
+			c.Set("permissions", permissions)
+
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			fail(c, http.StatusUnauthorized, "AUTH_REQUIRED", "Missing Authorization header")
@@ -62,8 +76,15 @@ func AuthRequired() gin.HandlerFunc {
 			return
 		}
 
+		if revocations.isRevoked(claims) {
+			fail(c, http.StatusUnauthorized, "TOKEN_REVOKED", "Token has been revoked")
+			c.Abort()
+			return
+		}
+
 		c.Set("userId", claims.UserID)
 		c.Set("roles", claims.Roles)
+		c.Set("permissions", claims.Permissions)
 
 		c.Next()
 	}
@@ -89,25 +110,35 @@ func getRoles(c *gin.Context) []string {
 	return nil
 }
 
-func hasAdminRole(c *gin.Context) bool {
-	for _, r := range getRoles(c) {
-		if r == "admin" {
-			return true
-		}
+func getPermissions(c *gin.Context) []string {
+	permsVal, ok := c.Get("permissions")
+	if !ok {
+		return nil
 	}
-	return false
+	if perms, ok := permsVal.([]string); ok {
+		return perms
+	}
+	return nil
 }
 
-func hasRole(c *gin.Context, target string) bool {
-	for _, r := range getRoles(c) {
-		if r == target {
+func hasPermission(c *gin.Context, permission string) bool {
+	for _, p := range getPermissions(c) {
+		if p == permission {
 			return true
 		}
 	}
 	return false
 }
 
-func isEngineer(c *gin.Context) bool { return hasRole(c, "engineer") }
-func isManager(c *gin.Context) bool  { return hasRole(c, "manager") }
-func isDirector(c *gin.Context) bool { return hasRole(c, "director") }
-func isCustomer(c *gin.Context) bool { return hasRole(c, "customer") }
+// проверка, что у пользователя есть право (например products:manage)
+func PermissionRequired(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if hasPermission(c, permission) {
+			c.Next()
+			return
+		}
+
+		fail(c, http.StatusForbidden, "FORBIDDEN", "Permission "+permission+" required")
+		c.Abort()
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -14,16 +15,37 @@ const (
 )
 
 var (
-	jwtSecretString string
-	tokenTTL        = 24 * time.Hour
+	jwksURL            string
+	revocationsURL     string
+	revocationInterval time.Duration
+	internalAuthSecret []byte
+	ordersPolicyPath   string
+	ordersWorkflowPath string
+	defaultCurrency    string
+	tokenTTL           = 24 * time.Hour
 )
 
 func initConfig() {
 	_ = godotenv.Load()
 
-	jwtSecretString = getenv("JWT_SECRET", "dev-secret-change-me")
+	// публичные ключи для проверки JWT публикует service_users
+	jwksURL = getenv("JWKS_URL", "http://localhost:8081/.well-known/jwks.json")
+	// денылист отозванных токенов (service_users)
+	revocationsURL = getenv("REVOCATIONS_URL", "http://localhost:8081/internal/revocations")
+	revocationInterval = getenvDuration("REVOCATION_POLL_INTERVAL", 15*time.Second)
+	// ключ проверки подписанных шлюзом заголовков личности (пусто — только Bearer)
+	internalAuthSecret = []byte(getenv("INTERNAL_AUTH_SECRET", ""))
+	// файл правил доступа к заказам (пусто — встроенный policy.yaml)
+	ordersPolicyPath = getenv("ORDERS_POLICY_FILE", "")
+	// файл жизненного цикла заказа (пусто — встроенный workflow.yaml)
+	ordersWorkflowPath = getenv("ORDERS_WORKFLOW_FILE", "")
+	// валюта товаров без явной currency и старых записей при миграции
+	defaultCurrency = strings.ToUpper(getenv("DEFAULT_CURRENCY", "USD"))
+	if !validCurrency(defaultCurrency) {
+		log.Fatalf("invalid DEFAULT_CURRENCY: %s", defaultCurrency)
+	}
 
-	log.Println("Config initialized for service_orders, JWT_SECRET length:", len(jwtSecretString))
+	log.Println("Config initialized for service_orders, JWKS:", jwksURL)
 }
 
 func getenv(key, def string) string {
@@ -32,3 +54,15 @@ func getenv(key, def string) string {
 	}
 	return def
 }
+
+func getenvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return d
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"log"
+	"time"
 
+	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -25,9 +29,51 @@ func initDB() error {
 	CREATE TABLE IF NOT EXISTS orders (
 		id TEXT PRIMARY KEY,
 		user_id TEXT NOT NULL,
-		items_json TEXT NOT NULL,
 		status TEXT NOT NULL,
-		total_amount REAL NOT NULL,
+		-- сумма в минимальных единицах валюты (ISO 4217)
+		total_minor INTEGER NOT NULL,
+		currency TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+
+	-- позиции заказа; цена — снимок каталога на момент добавления, в валюте заказа
+	CREATE TABLE IF NOT EXISTS order_items (
+		id TEXT PRIMARY KEY,
+		order_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		sku TEXT NOT NULL,
+		product TEXT NOT NULL,
+		quantity INTEGER NOT NULL,
+		unit_price_minor INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_order_items_order ON order_items(order_id, position);
+	CREATE INDEX IF NOT EXISTS idx_order_items_sku ON order_items(sku);
+
+	-- журнал смены статусов: кто, когда и почему; from_status пуст у записи о создании
+	CREATE TABLE IF NOT EXISTS order_status_history (
+		id TEXT PRIMARY KEY,
+		order_id TEXT NOT NULL,
+		from_status TEXT NOT NULL,
+		to_status TEXT NOT NULL,
+		actor_id TEXT NOT NULL,
+		actor_roles TEXT NOT NULL,
+		comment TEXT NOT NULL,
+		request_id TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_order_status_history_order ON order_status_history(order_id, created_at);
+
+	-- каталог товаров: цена заказа считается по нему, а не со слов клиента
+	CREATE TABLE IF NOT EXISTS products (
+		sku TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		unit_price_minor INTEGER NOT NULL,
+		currency TEXT NOT NULL,
+		active INTEGER NOT NULL,
 		created_at DATETIME NOT NULL,
 		updated_at DATETIME NOT NULL
 	);
@@ -36,7 +82,258 @@ func initDB() error {
 		return err
 	}
 
+	if err := migrateMoney(d); err != nil {
+		return err
+	}
+	if err := migrateOrderItems(d); err != nil {
+		return err
+	}
+
 	db = d
 	log.Println("SQLite for orders initialized at", dbPath)
 	return nil
 }
+
+func hasColumn(d *sql.DB, table, column string) (bool, error) {
+	rows, err := d.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// Суммы раньше хранились как REAL без валюты. Переводим их в минимальные
+// единицы DEFAULT_CURRENCY: заказы, цены в items_json и каталог.
+func migrateMoney(d *sql.DB) error {
+	ordersOld, err := hasColumn(d, "orders", "total_amount")
+	if err != nil {
+		return err
+	}
+	productsOld, err := hasColumn(d, "products", "unit_price")
+	if err != nil {
+		return err
+	}
+	if !ordersOld && !productsOld {
+		return nil
+	}
+
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if ordersOld {
+		if err := migrateOrdersMoney(tx); err != nil {
+			return err
+		}
+	}
+	if productsOld {
+		if err := migrateProductsMoney(tx); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("DB migration: amounts converted to minor units of %s", defaultCurrency)
+	return nil
+}
+
+func migrateOrdersMoney(tx *sql.Tx) error {
+	stmts := []string{
+		`ALTER TABLE orders ADD COLUMN total_minor INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE orders ADD COLUMN currency TEXT NOT NULL DEFAULT ''`,
+	}
+	for _, q := range stmts {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	type oldItem struct {
+		SKU       string  `json:"sku,omitempty"`
+		Product   string  `json:"product"`
+		Quantity  int     `json:"quantity"`
+		UnitPrice float64 `json:"unitPrice"`
+	}
+	type oldOrder struct {
+		id, itemsJSON string
+		total         float64
+	}
+
+	rows, err := tx.Query(`SELECT id, items_json, total_amount FROM orders`)
+	if err != nil {
+		return err
+	}
+	var orders []oldOrder
+	for rows.Next() {
+		var o oldOrder
+		if err := rows.Scan(&o.id, &o.itemsJSON, &o.total); err != nil {
+			rows.Close()
+			return err
+		}
+		orders = append(orders, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, o := range orders {
+		var old []oldItem
+		if err := json.Unmarshal([]byte(o.itemsJSON), &old); err != nil {
+			return fmt.Errorf("order %s: %w", o.id, err)
+		}
+		items := make([]legacyOrderItem, 0, len(old))
+		for _, it := range old {
+			items = append(items, legacyOrderItem{
+				SKU:       it.SKU,
+				Product:   it.Product,
+				Quantity:  it.Quantity,
+				UnitPrice: moneyFromFloat(it.UnitPrice, defaultCurrency).Minor,
+			})
+		}
+		itemsJSON, err := json.Marshal(items)
+		if err != nil {
+			return err
+		}
+		total := moneyFromFloat(o.total, defaultCurrency)
+		if _, err := tx.Exec(`UPDATE orders SET items_json = ?, total_minor = ?, currency = ? WHERE id = ?`,
+			string(itemsJSON), total.Minor, total.Currency, o.id); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`ALTER TABLE orders DROP COLUMN total_amount`)
+	return err
+}
+
+func migrateProductsMoney(tx *sql.Tx) error {
+	stmts := []string{
+		`ALTER TABLE products ADD COLUMN unit_price_minor INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE products ADD COLUMN currency TEXT NOT NULL DEFAULT ''`,
+	}
+	for _, q := range stmts {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.Query(`SELECT sku, unit_price FROM products`)
+	if err != nil {
+		return err
+	}
+	prices := make(map[string]float64)
+	for rows.Next() {
+		var sku string
+		var price float64
+		if err := rows.Scan(&sku, &price); err != nil {
+			rows.Close()
+			return err
+		}
+		prices[sku] = price
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for sku, price := range prices {
+		m := moneyFromFloat(price, defaultCurrency)
+		if _, err := tx.Exec(`UPDATE products SET unit_price_minor = ?, currency = ? WHERE sku = ?`,
+			m.Minor, m.Currency, sku); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`ALTER TABLE products DROP COLUMN unit_price`)
+	return err
+}
+
+// позиция в старой колонке orders.items_json
+type legacyOrderItem struct {
+	SKU       string `json:"sku,omitempty"`
+	Product   string `json:"product"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice int64  `json:"unitPriceMinor"`
+}
+
+// Позиции раньше лежали JSON-ом в orders.items_json. Переносим их в order_items;
+// статус позиции берётся из статуса заказа.
+func migrateOrderItems(d *sql.DB) error {
+	ok, err := hasColumn(d, "orders", "items_json")
+	if err != nil || !ok {
+		return err
+	}
+
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	type oldOrder struct {
+		id, itemsJSON, status string
+		createdAt             time.Time
+	}
+
+	rows, err := tx.Query(`SELECT id, items_json, status, created_at FROM orders`)
+	if err != nil {
+		return err
+	}
+	var orders []oldOrder
+	for rows.Next() {
+		var o oldOrder
+		if err := rows.Scan(&o.id, &o.itemsJSON, &o.status, &o.createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		orders = append(orders, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, o := range orders {
+		var items []legacyOrderItem
+		if err := json.Unmarshal([]byte(o.itemsJSON), &items); err != nil {
+			return fmt.Errorf("order %s: %w", o.id, err)
+		}
+		status := ItemPending
+		if s, ok := orderWorkflow.itemStatus(OrderStatus(o.status)); ok {
+			status = s
+		}
+		for i, it := range items {
+			if _, err := tx.Exec(
+				`INSERT INTO order_items (id, order_id, position, sku, product, quantity, unit_price_minor, status, created_at, updated_at)
+				 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				uuid.NewString(), o.id, i+1, it.SKU, it.Product, it.Quantity, it.UnitPrice, string(status), o.createdAt, o.createdAt,
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec(`ALTER TABLE orders DROP COLUMN items_json`); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("DB migration: moved items of %d orders to order_items", len(orders))
+	return nil
+}
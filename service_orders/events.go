@@ -5,8 +5,8 @@ import "log"
 // Публикация события "создан заказ"
 func publishOrderCreated(o *Order, requestID string) {
 	log.Printf(
-		`event=order.created requestId=%s orderId=%s userId=%s status=%s total=%.2f`,
-		requestID, o.ID, o.UserID, o.Status, o.TotalAmount,
+		`event=order.created requestId=%s orderId=%s userId=%s status=%s total=%s currency=%s`,
+		requestID, o.ID, o.UserID, o.Status, o.TotalAmount, o.Currency,
 	)
 }
 
@@ -17,3 +17,11 @@ func publishOrderStatusUpdated(o *Order, oldStatus, newStatus OrderStatus, reque
 		requestID, o.ID, o.UserID, oldStatus, newStatus,
 	)
 }
+
+// Публикация события "изменены позиции заказа"
+func publishOrderItemsUpdated(o *Order, requestID string) {
+	log.Printf(
+		`event=order.items_updated requestId=%s orderId=%s userId=%s items=%d total=%s currency=%s`,
+		requestID, o.ID, o.UserID, len(o.Items), o.TotalAmount, o.Currency,
+	)
+}
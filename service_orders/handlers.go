@@ -1,41 +1,78 @@
 package main
 
 import (
-	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 type OrderItemRequest struct {
-	Product  string `json:"product" binding:"required"`
-	Quantity int    `json:"quantity" binding:"required,gt=0"`
+	SKU      string `json:"sku" binding:"required"`
+	Quantity int    `json:"quantity" binding:"required,gt=0,lte=10000"`
 }
 
 type CreateOrderRequest struct {
-	Items       []OrderItemRequest `json:"items" binding:"required"`
-	TotalAmount float64            `json:"totalAmount" binding:"required,gt=0"`
+	Items []OrderItemRequest `json:"items" binding:"required"`
+	// ISO 4217; пусто — валюта товаров из каталога
+	Currency string `json:"currency"`
+	// необязательно: сумма считается по каталогу, переданная клиентом только сверяется
+	TotalAmount *decimalAmount `json:"totalAmount"`
 }
 
 type UpdateStatusRequest struct {
-	Status string `json:"status" binding:"required"` // in_progress / done / cancelled
+	Status  string `json:"status" binding:"required"`  // один из статусов workflow
+	Comment string `json:"comment" binding:"max=1000"` // может требоваться переходом
 }
 
-func parseStatus(s string) (OrderStatus, bool) {
-	switch s {
-	case "created":
-		return StatusCreated, true
-	case "in_progress":
-		return StatusInProgress, true
-	case "done":
-		return StatusDone, true
-	case "cancelled":
-		return StatusCancelled, true
-	default:
-		return "", false
+// тело POST /cancel необязательно
+type CancelOrderRequest struct {
+	Comment string `json:"comment" binding:"max=1000"`
+}
+
+// отказ в смене статуса; false — ошибки не было
+func failStatusChange(c *gin.Context, err error, msg string) bool {
+	if err == nil {
+		return false
+	}
+	var te *TransitionError
+	if errors.As(err, &te) {
+		fail(c, te.HTTPStatus, te.Code, te.Message)
+		return true
+	}
+	fail(c, http.StatusInternalServerError, "DB_ERROR", msg)
+	return true
+}
+
+// запись журнала статусов от имени текущего пользователя
+func statusChange(c *gin.Context, comment string) *StatusChange {
+	userID, _ := getUserID(c)
+	return &StatusChange{
+		ActorID:          userID,
+		ActorRoles:       getRoles(c),
+		ActorPermissions: getPermissions(c),
+		Comment:          strings.TrimSpace(comment),
+		RequestID:        getRequestID(c),
+	}
+}
+
+// page/limit из query: limit по умолчанию 10, не больше 100
+func pagination(c *gin.Context) (page, limit, offset int) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err = strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 {
+		limit = 10
+	}
+	if limit > 100 {
+		limit = 100
 	}
+	return page, limit, (page - 1) * limit
 }
 
 // POST /v1/orders
@@ -50,10 +87,6 @@ func handleCreateOrder(c *gin.Context) {
 		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "At least one item is required")
 		return
 	}
-	if req.TotalAmount <= 0 {
-		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "Total amount must be > 0")
-		return
-	}
 
 	userID, ok := getUserID(c)
 	if !ok {
@@ -61,27 +94,45 @@ func handleCreateOrder(c *gin.Context) {
 		return
 	}
 
-	items := make([]OrderItem, 0, len(req.Items))
-	for _, it := range req.Items {
-		if it.Product == "" || it.Quantity <= 0 {
-			fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid item product or quantity")
+	currency := strings.ToUpper(strings.TrimSpace(req.Currency))
+	if currency != "" && !validCurrency(currency) {
+		fail(c, http.StatusBadRequest, "INVALID_CURRENCY", "Unsupported currency: "+currency)
+		return
+	}
+
+	items, total, ok := priceOrderItems(c, req.Items, currency)
+	if !ok {
+		return
+	}
+	// клиент мог показать пользователю устаревшую цену — не оформляем молча
+	if req.TotalAmount != nil {
+		clientTotal, err := parseMoney(string(*req.TotalAmount), total.Currency)
+		if err != nil {
+			fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "totalAmount: "+err.Error())
+			return
+		}
+		if clientTotal != total {
+			failWithDetails(c, http.StatusBadRequest, "TOTAL_MISMATCH",
+				"totalAmount does not match the catalog prices",
+				gin.H{"expectedTotal": total, "clientTotal": clientTotal, "currency": total.Currency})
 			return
 		}
-		items = append(items, OrderItem{
-			Product:  it.Product,
-			Quantity: it.Quantity,
-		})
 	}
 
 	order := &Order{
 		ID:          uuid.NewString(),
 		UserID:      userID,
 		Items:       items,
-		Status:      StatusCreated,
-		TotalAmount: req.TotalAmount,
+		Status:      orderWorkflow.Initial,
+		TotalAmount: total,
+		Currency:    total.Currency,
+	}
+
+	if !authorize(c, actionCreate, order) {
+		return
 	}
 
-	if err := insertOrder(order); err != nil {
+	if err := insertOrder(order, statusChange(c, "")); err != nil {
 		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to create order")
 		return
 	}
@@ -106,23 +157,14 @@ func handleGetOrder(c *gin.Context) {
 		return
 	}
 
-	userID, ok := getUserID(c)
-	if !ok {
-		fail(c, http.StatusInternalServerError, "CONTEXT_ERROR", "User ID missing in context")
-		return
-	}
-
-	// правило: владелец или админ/менеджер/директор/заказчик?
-	// по ТЗ достаточно "владелец или админ", но можно дать доступ и менеджеру/директору/заказчику для просмотра
-	if order.UserID != userID && !hasAdminRole(c) && !isManager(c) && !isDirector(c) && !isCustomer(c) {
-		fail(c, http.StatusForbidden, "FORBIDDEN", "You are not allowed to view this order")
+	if !authorize(c, actionRead, order) {
 		return
 	}
 
 	success(c, order)
 }
 
-// GET /v1/orders
+// GET /v1/orders?page=&limit=&sort=&sku=
 func handleListMyOrders(c *gin.Context) {
 	userID, ok := getUserID(c)
 	if !ok {
@@ -130,45 +172,40 @@ func handleListMyOrders(c *gin.Context) {
 		return
 	}
 
-	pageStr := c.DefaultQuery("page", "1")
-	limitStr := c.DefaultQuery("limit", "10")
+	page, limit, offset := pagination(c)
 	sortStr := c.DefaultQuery("sort", "desc") // desc / asc
-
-	page, err := strconv.Atoi(pageStr)
-	if err != nil || page < 1 {
-		page = 1
-	}
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit < 1 {
-		limit = 10
-	}
-	if limit > 100 {
-		limit = 100
-	}
-	offset := (page - 1) * limit
+	sku := strings.TrimSpace(c.Query("sku"))  // только заказы с этим товаром
 
 	sortDesc := true
 	if sortStr == "asc" {
 		sortDesc = false
 	}
 
-	total, err := getOrdersCountForUser(userID)
+	total, err := getOrdersCountForUser(userID, sku)
 	if err != nil {
 		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to count orders")
 		return
 	}
 
-	orders, err := listOrdersForUser(userID, limit, offset, sortDesc)
+	orders, err := listOrdersForUser(userID, sku, limit, offset, sortDesc)
 	if err != nil {
 		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to list orders")
 		return
 	}
 
+	// суммы по всем заказам пользователя, отдельно по каждой валюте
+	totals, err := getOrderTotalsForUser(userID, sku)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to sum orders")
+		return
+	}
+
 	success(c, gin.H{
-		"items": orders,
-		"page":  page,
-		"limit": limit,
-		"total": total,
+		"items":  orders,
+		"page":   page,
+		"limit":  limit,
+		"total":  total,
+		"totals": totals,
 	})
 }
 
@@ -182,10 +219,10 @@ func handleUpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
-	newStatus, ok := parseStatus(req.Status)
+	newStatus, ok := orderWorkflow.status(req.Status)
 	if !ok {
 		fail(c, http.StatusBadRequest, "INVALID_STATUS",
-			"Status must be one of: created, in_progress, done, cancelled")
+			"Status must be one of: "+orderWorkflow.statusNames())
 		return
 	}
 
@@ -199,36 +236,14 @@ func handleUpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
-	userID, ok := getUserID(c)
-	if !ok {
-		fail(c, http.StatusInternalServerError, "CONTEXT_ERROR", "User ID missing in context")
-		return
-	}
-
-	// права:
-	// - admin / manager: могут менять любой заказ
-	// - engineer: только свои
-	// - director/customer: не могут менять
-	if hasAdminRole(c) || isManager(c) {
-		// ок
-	} else if isEngineer(c) {
-		if order.UserID != userID {
-			fail(c, http.StatusForbidden, "FORBIDDEN", "Engineer can update only own orders")
-			return
-		}
-	} else {
-		fail(c, http.StatusForbidden, "FORBIDDEN", "You are not allowed to update orders")
+	if !authorize(c, actionUpdate, order) {
 		return
 	}
 
 	oldStatus := order.Status
 
-	if err := updateOrderStatus(order, newStatus); err != nil {
-		if err == sql.ErrNoRows {
-			fail(c, http.StatusBadRequest, "INVALID_TRANSITION", "Status transition is not allowed")
-			return
-		}
-		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to update order status")
+	err = updateOrderStatus(order, newStatus, statusChange(c, req.Comment))
+	if failStatusChange(c, err, "Failed to update order status") {
 		return
 	}
 
@@ -237,10 +252,18 @@ func handleUpdateOrderStatus(c *gin.Context) {
 	success(c, order)
 }
 
-// POST /v1/orders/:id/cancel
+// POST /v1/orders/:id/cancel — тело {"comment"} необязательно
 func handleCancelOrder(c *gin.Context) {
 	orderID := c.Param("id")
 
+	var req CancelOrderRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+			return
+		}
+	}
+
 	order, err := getOrderByID(orderID)
 	if err != nil {
 		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to get order")
@@ -251,32 +274,47 @@ func handleCancelOrder(c *gin.Context) {
 		return
 	}
 
-	userID, ok := getUserID(c)
-	if !ok {
-		fail(c, http.StatusInternalServerError, "CONTEXT_ERROR", "User ID missing in context")
+	if !authorize(c, actionCancel, order) {
 		return
 	}
 
-	// владелец, менеджер или админ могут отменять
-	if !(order.UserID == userID || hasAdminRole(c) || isManager(c)) {
-		fail(c, http.StatusForbidden, "FORBIDDEN", "You are not allowed to cancel this order")
+	oldStatus := order.Status
+
+	err = updateOrderStatus(order, orderWorkflow.Cancel, statusChange(c, req.Comment))
+	if failStatusChange(c, err, "Failed to cancel order") {
 		return
 	}
 
-	oldStatus := order.Status
+	publishOrderStatusUpdated(order, oldStatus, orderWorkflow.Cancel, getRequestID(c))
 
-	if err := updateOrderStatus(order, StatusCancelled); err != nil {
-		if err == sql.ErrNoRows {
-			fail(c, http.StatusBadRequest, "INVALID_TRANSITION", "Cannot cancel order in this status")
-			return
-		}
-		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to cancel order")
+	success(c, order)
+}
+
+// GET /v1/orders/:id/history — видна тем же, кому виден сам заказ
+func handleGetOrderHistory(c *gin.Context) {
+	order, err := getOrderByID(c.Param("id"))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to get order")
+		return
+	}
+	if order == nil {
+		fail(c, http.StatusNotFound, "ORDER_NOT_FOUND", "Order not found")
 		return
 	}
 
-	publishOrderStatusUpdated(order, oldStatus, StatusCancelled, getRequestID(c))
+	if !authorize(c, actionRead, order) {
+		return
+	}
 
-	success(c, order)
+	history, err := listStatusHistory(order.ID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to get order history")
+		return
+	}
+
+	success(c, gin.H{
+		"items": history,
+	})
 }
 
 // DELETE /v1/orders/:id
@@ -293,29 +331,10 @@ func handleDeleteOrder(c *gin.Context) {
 		return
 	}
 
-	userID, ok := getUserID(c)
-	if !ok {
-		fail(c, http.StatusInternalServerError, "CONTEXT_ERROR", "User ID missing in context")
+	if !authorize(c, actionDelete, order) {
 		return
 	}
 
-	// правило удаления:
-	// - владелец может удалять только свои заказы в статусе created или cancelled
-	// - admin/manager могут удалять любой заказ
-	if hasAdminRole(c) || isManager(c) {
-		// ok
-	} else {
-		if order.UserID != userID {
-			fail(c, http.StatusForbidden, "FORBIDDEN", "You are not allowed to delete this order")
-			return
-		}
-		if order.Status != StatusCreated && order.Status != StatusCancelled {
-			fail(c, http.StatusBadRequest, "INVALID_STATE",
-				"Only orders in status 'created' or 'cancelled' can be deleted by owner")
-			return
-		}
-	}
-
 	if err := deleteOrder(order); err != nil {
 		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to delete order")
 		return
@@ -326,3 +345,12 @@ func handleDeleteOrder(c *gin.Context) {
 		"deleted": true,
 	})
 }
+
+// GET /healthz — проба для api_gateway
+func handleHealthz(c *gin.Context) {
+	if err := db.Ping(); err != nil {
+		fail(c, http.StatusServiceUnavailable, "DB_UNAVAILABLE", "Database is not available")
+		return
+	}
+	success(c, gin.H{"status": "ok"})
+}
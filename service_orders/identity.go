@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// подписанная личность пользователя, которую проставляет api_gateway
+const (
+	headerUserID            = "X-User-ID"
+	headerUserRoles         = "X-User-Roles"
+	headerUserPermissions   = "X-User-Permissions"
+	headerIdentityTimestamp = "X-Identity-Timestamp"
+	headerIdentitySignature = "X-Identity-Signature"
+)
+
+// допустимое расхождение часов шлюза и сервиса
+const identityMaxSkew = 5 * time.Minute
+
+var errInvalidIdentity = errors.New("invalid identity headers")
+
+func signIdentity(secret []byte, userID, roles, permissions, requestID, ts string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(userID + "\n" + roles + "\n" + permissions + "\n" + requestID + "\n" + ts))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// пришли ли заголовки личности и умеем ли мы их проверять
+func hasIdentityHeaders(c *gin.Context) bool {
+	return len(internalAuthSecret) > 0 && c.GetHeader(headerIdentitySignature) != ""
+}
+
+// проверка подписи и свежести; возвращает userId, роли и права
+func verifyIdentityHeaders(c *gin.Context) (string, []string, []string, error) {
+	userID := c.GetHeader(headerUserID)
+	roles := c.GetHeader(headerUserRoles)
+	permissions := c.GetHeader(headerUserPermissions)
+	ts := c.GetHeader(headerIdentityTimestamp)
+	sig := c.GetHeader(headerIdentitySignature)
+
+	if userID == "" || ts == "" {
+		return "", nil, nil, errInvalidIdentity
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", nil, nil, errInvalidIdentity
+	}
+	age := time.Since(time.Unix(unix, 0))
+	if age > identityMaxSkew || age < -identityMaxSkew {
+		return "", nil, nil, errInvalidIdentity
+	}
+
+	expected := signIdentity(internalAuthSecret, userID, roles, permissions, getRequestID(c), ts)
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return "", nil, nil, errInvalidIdentity
+	}
+
+	return userID, splitList(roles), splitList(permissions), nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
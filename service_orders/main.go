@@ -9,10 +9,21 @@ import (
 func main() {
 	initConfig()
 
+	// статусы из workflow нужны правилам доступа и миграциям
+	if err := initWorkflow(); err != nil {
+		log.Fatalf("failed to load orders workflow: %v", err)
+	}
+
+	if err := initPolicy(); err != nil {
+		log.Fatalf("failed to load orders policy: %v", err)
+	}
+
 	if err := initDB(); err != nil {
 		log.Fatalf("failed to init orders database: %v", err)
 	}
 
+	go runRevocationSync(revocationInterval)
+
 	// не Default, чтобы контролировать middleware сами
 	router := gin.New()
 	router.Use(
@@ -21,6 +32,9 @@ func main() {
 		LoggingMiddleware(),
 	)
 
+	router.GET("/healthz", handleHealthz)
+	router.POST("/internal/revocations/notify", InternalRequired(), handleRevocationNotify)
+
 	api := router.Group("/v1")
 	{
 		orders := api.Group("/orders")
@@ -29,11 +43,29 @@ func main() {
 
 			orders.POST("", handleCreateOrder)
 			orders.GET("/:id", handleGetOrder)
+			orders.GET("/:id/history", handleGetOrderHistory)
 			orders.GET("", handleListMyOrders)
+			orders.GET("/workflow", handleGetWorkflow)
 
 			orders.PATCH("/:id/status", handleUpdateOrderStatus)
 			orders.POST("/:id/cancel", handleCancelOrder)
 			orders.DELETE("/:id", handleDeleteOrder)
+
+			orders.POST("/:id/items", handleAddOrderItem)
+			orders.PATCH("/:id/items/:lineId", handleUpdateOrderItem)
+			orders.DELETE("/:id/items/:lineId", handleDeleteOrderItem)
+		}
+
+		products := api.Group("/products")
+		{
+			products.Use(AuthRequired())
+
+			products.GET("", handleListProducts)
+			products.GET("/:sku", handleGetProduct)
+
+			products.POST("", PermissionRequired(permProductsManage), handleCreateProduct)
+			products.PATCH("/:sku", PermissionRequired(permProductsManage), handleUpdateProduct)
+			products.DELETE("/:sku", PermissionRequired(permProductsManage), handleDeleteProduct)
 		}
 	}
 
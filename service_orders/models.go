@@ -2,22 +2,45 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
+// статусы и переходы между ними задаёт workflow.yaml
 type OrderStatus string
 
+// статус позиции: меняется вместе с заказом (см. items в workflow.yaml)
+type OrderItemStatus string
+
 const (
-	StatusCreated    OrderStatus = "created"
-	StatusInProgress OrderStatus = "in_progress"
-	StatusDone       OrderStatus = "done"
-	StatusCancelled  OrderStatus = "cancelled"
+	ItemPending   OrderItemStatus = "pending"
+	ItemFulfilled OrderItemStatus = "fulfilled"
+	ItemCancelled OrderItemStatus = "cancelled"
+)
+
+var (
+	// заказ ушёл из начального статуса — позиции менять нельзя
+	errOrderNotEditable = errors.New("order is not editable")
+	// у заказа должна остаться хотя бы одна позиция
+	errLastOrderItem = errors.New("cannot remove the last order item")
+	// сумма заказа не сходится с позициями (заказ из старой схемы, где у позиций
+	// не было цены): пересчёт по позициям затёр бы настоящую сумму
+	errOrderItemsUnpriced = errors.New("order total does not match its items")
 )
 
+// позиция заказа: название и цена фиксируются на момент заказа,
+// чтобы последующие изменения каталога не меняли старые заказы
 type OrderItem struct {
-	Product  string `json:"product"`
-	Quantity int    `json:"quantity"`
+	ID        string          `json:"id"`
+	SKU       string          `json:"sku,omitempty"`
+	Product   string          `json:"product"`
+	Quantity  int             `json:"quantity"`
+	UnitPrice Money           `json:"unitPrice"` // в валюте заказа
+	Status    OrderItemStatus `json:"status"`
 }
 
 type Order struct {
@@ -25,57 +48,196 @@ type Order struct {
 	UserID      string      `json:"userId"`
 	Items       []OrderItem `json:"items"`
 	Status      OrderStatus `json:"status"`
-	TotalAmount float64     `json:"totalAmount"`
+	TotalAmount Money       `json:"totalAmount"`
+	Currency    string      `json:"currency"`
 	CreatedAt   time.Time   `json:"createdAt"`
 	UpdatedAt   time.Time   `json:"updatedAt"`
 }
 
-func insertOrder(o *Order) error {
+// запись журнала статусов; From пуст у записи о создании заказа
+type StatusChange struct {
+	ID         string      `json:"id"`
+	From       OrderStatus `json:"from,omitempty"`
+	To         OrderStatus `json:"to"`
+	ActorID    string      `json:"actorId"`
+	ActorRoles []string    `json:"actorRoles"`
+	Comment    string      `json:"comment,omitempty"`
+	RequestID  string      `json:"requestId,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+
+	// права на момент перехода — для проверки по workflow, в журнал не пишутся
+	ActorPermissions []string `json:"-"`
+}
+
+func insertStatusChangeTx(tx *sql.Tx, orderID string, ch *StatusChange) error {
+	ch.ID = uuid.NewString()
+	if ch.ActorRoles == nil {
+		ch.ActorRoles = []string{}
+	}
+
+	_, err := tx.Exec(
+		`INSERT INTO order_status_history (id, order_id, from_status, to_status, actor_id, actor_roles, comment, request_id, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ch.ID, orderID, string(ch.From), string(ch.To), ch.ActorID, strings.Join(ch.ActorRoles, ","),
+		ch.Comment, ch.RequestID, ch.CreatedAt,
+	)
+	return err
+}
+
+// журнал статусов заказа, старые записи сверху
+func listStatusHistory(orderID string) ([]*StatusChange, error) {
+	rows, err := db.Query(
+		`SELECT id, from_status, to_status, actor_id, actor_roles, comment, request_id, created_at
+		 FROM order_status_history WHERE order_id = ? ORDER BY created_at, rowid`,
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make([]*StatusChange, 0)
+	for rows.Next() {
+		var ch StatusChange
+		var from, to, roles string
+		if err := rows.Scan(&ch.ID, &from, &to, &ch.ActorID, &roles, &ch.Comment, &ch.RequestID, &ch.CreatedAt); err != nil {
+			return nil, err
+		}
+		ch.From = OrderStatus(from)
+		ch.To = OrderStatus(to)
+		ch.ActorRoles = splitList(roles)
+		history = append(history, &ch)
+	}
+	return history, rows.Err()
+}
+
+// created — запись о создании в журнал статусов (From и To заполняются здесь)
+func insertOrder(o *Order, created *StatusChange) error {
 	now := time.Now()
 	o.CreatedAt = now
 	o.UpdatedAt = now
 
-	itemsJSON, err := json.Marshal(o.Items)
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = db.Exec(
-		`INSERT INTO orders (id, user_id, items_json, status, total_amount, created_at, updated_at)
+	_, err = tx.Exec(
+		`INSERT INTO orders (id, user_id, status, total_minor, currency, created_at, updated_at)
 		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		o.ID, o.UserID, string(itemsJSON), string(o.Status), o.TotalAmount, o.CreatedAt, o.UpdatedAt,
+		o.ID, o.UserID, string(o.Status), o.TotalAmount.Minor, o.Currency, o.CreatedAt, o.UpdatedAt,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	for i := range o.Items {
+		if err := insertOrderItemTx(tx, o.ID, i+1, &o.Items[i], now); err != nil {
+			return err
+		}
+	}
+
+	created.From = ""
+	created.To = o.Status
+	created.CreatedAt = now
+	if err := insertStatusChangeTx(tx, o.ID, created); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func getOrderByID(id string) (*Order, error) {
-	row := db.QueryRow(
-		`SELECT id, user_id, items_json, status, total_amount, created_at, updated_at
-		 FROM orders WHERE id = ?`,
-		id,
+// новая позиция получает id и статус pending; position задаёт порядок в заказе
+func insertOrderItemTx(tx *sql.Tx, orderID string, position int, it *OrderItem, now time.Time) error {
+	it.ID = uuid.NewString()
+	it.Status = ItemPending
+
+	_, err := tx.Exec(
+		`INSERT INTO order_items (id, order_id, position, sku, product, quantity, unit_price_minor, status, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		it.ID, orderID, position, it.SKU, it.Product, it.Quantity, it.UnitPrice.Minor, string(it.Status), now, now,
 	)
+	return err
+}
 
+const orderColumns = `id, user_id, status, total_minor, currency, created_at, updated_at`
+
+func scanOrder(row interface{ Scan(...any) error }) (*Order, error) {
 	var o Order
-	var itemsJSON string
 	var statusStr string
+	var totalMinor int64
 
-	if err := row.Scan(&o.ID, &o.UserID, &itemsJSON, &statusStr, &o.TotalAmount, &o.CreatedAt, &o.UpdatedAt); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
+	if err := row.Scan(&o.ID, &o.UserID, &statusStr, &totalMinor, &o.Currency, &o.CreatedAt, &o.UpdatedAt); err != nil {
 		return nil, err
 	}
 
-	if err := json.Unmarshal([]byte(itemsJSON), &o.Items); err != nil {
-		return nil, err
-	}
 	o.Status = OrderStatus(statusStr)
+	o.TotalAmount = Money{Minor: totalMinor, Currency: o.Currency}
+	o.Items = []OrderItem{}
 
 	return &o, nil
 }
 
-func getOrdersCountForUser(userID string) (int, error) {
-	row := db.QueryRow(`SELECT COUNT(*) FROM orders WHERE user_id = ?`, userID)
+// подгрузить позиции сразу для нескольких заказов одним запросом
+func loadOrderItems(orders []*Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*Order, len(orders))
+	args := make([]any, 0, len(orders))
+	for _, o := range orders {
+		byID[o.ID] = o
+		args = append(args, o.ID)
+	}
+
+	rows, err := db.Query(
+		`SELECT id, order_id, sku, product, quantity, unit_price_minor, status
+		 FROM order_items
+		 WHERE order_id IN (?`+strings.Repeat(", ?", len(orders)-1)+`)
+		 ORDER BY order_id, position`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var it OrderItem
+		var orderID, statusStr string
+		var priceMinor int64
+		if err := rows.Scan(&it.ID, &orderID, &it.SKU, &it.Product, &it.Quantity, &priceMinor, &statusStr); err != nil {
+			return err
+		}
+		o := byID[orderID]
+		it.UnitPrice = Money{Minor: priceMinor, Currency: o.Currency}
+		it.Status = OrderItemStatus(statusStr)
+		o.Items = append(o.Items, it)
+	}
+	return rows.Err()
+}
+
+func getOrderByID(id string) (*Order, error) {
+	o, err := scanOrder(db.QueryRow(`SELECT `+orderColumns+` FROM orders WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loadOrderItems([]*Order{o}); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// заказы пользователя; sku (если не пусто) — только заказы с этим товаром
+const userOrdersFilter = `user_id = ? AND (? = '' OR id IN (SELECT order_id FROM order_items WHERE sku = ?))`
+
+func getOrdersCountForUser(userID, sku string) (int, error) {
+	row := db.QueryRow(`SELECT COUNT(*) FROM orders WHERE `+userOrdersFilter, userID, sku, sku)
 	var count int
 	if err := row.Scan(&count); err != nil {
 		return 0, err
@@ -83,19 +245,19 @@ func getOrdersCountForUser(userID string) (int, error) {
 	return count, nil
 }
 
-func listOrdersForUser(userID string, limit, offset int, sortDesc bool) ([]*Order, error) {
+func listOrdersForUser(userID, sku string, limit, offset int, sortDesc bool) ([]*Order, error) {
 	orderDir := "ASC"
 	if sortDesc {
 		orderDir = "DESC"
 	}
 
 	rows, err := db.Query(
-		`SELECT id, user_id, items_json, status, total_amount, created_at, updated_at
+		`SELECT `+orderColumns+`
 		 FROM orders
-		 WHERE user_id = ?
+		 WHERE `+userOrdersFilter+`
 		 ORDER BY created_at `+orderDir+`
 		 LIMIT ? OFFSET ?`,
-		userID, limit, offset,
+		userID, sku, sku, limit, offset,
 	)
 	if err != nil {
 		return nil, err
@@ -104,61 +266,364 @@ func listOrdersForUser(userID string, limit, offset int, sortDesc bool) ([]*Orde
 
 	var orders []*Order
 	for rows.Next() {
-		var o Order
-		var itemsJSON, statusStr string
-		if err := rows.Scan(&o.ID, &o.UserID, &itemsJSON, &statusStr, &o.TotalAmount, &o.CreatedAt, &o.UpdatedAt); err != nil {
+		o, err := scanOrder(rows)
+		if err != nil {
 			return nil, err
 		}
-		if err := json.Unmarshal([]byte(itemsJSON), &o.Items); err != nil {
-			return nil, err
-		}
-		o.Status = OrderStatus(statusStr)
-		orders = append(orders, &o)
+		orders = append(orders, o)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	rows.Close()
+
+	if err := loadOrderItems(orders); err != nil {
+		return nil, err
+	}
 	return orders, nil
 }
 
-// проверить, допустим ли переход статуса
-func canTransitionStatus(from, to OrderStatus) bool {
-	if from == to {
-		return true
+// итог по валюте: суммы в разных валютах не складываются
+type CurrencyTotal struct {
+	Currency    string `json:"currency"`
+	Orders      int    `json:"orders"`
+	TotalAmount Money  `json:"totalAmount"`
+}
+
+// суммы всех заказов пользователя с разбивкой по валютам
+func getOrderTotalsForUser(userID, sku string) ([]CurrencyTotal, error) {
+	rows, err := db.Query(
+		`SELECT currency, COUNT(*), SUM(total_minor) FROM orders
+		 WHERE `+userOrdersFilter+` GROUP BY currency ORDER BY currency`,
+		userID, sku, sku,
+	)
+	if err != nil {
+		return nil, err
 	}
-	switch from {
-	case StatusCreated:
-		return to == StatusInProgress || to == StatusCancelled
-	case StatusInProgress:
-		return to == StatusDone || to == StatusCancelled
-	case StatusDone, StatusCancelled:
-		// финальные – дальше нельзя
-		return false
-	default:
-		return false
+	defer rows.Close()
+
+	totals := make([]CurrencyTotal, 0)
+	for rows.Next() {
+		var t CurrencyTotal
+		var sum int64
+		if err := rows.Scan(&t.Currency, &t.Orders, &sum); err != nil {
+			return nil, err
+		}
+		t.TotalAmount = Money{Minor: sum, Currency: t.Currency}
+		totals = append(totals, t)
 	}
+	return totals, rows.Err()
 }
 
-// обновление статуса в БД (и в объекте)
-func updateOrderStatus(o *Order, newStatus OrderStatus) error {
-	if !canTransitionStatus(o.Status, newStatus) {
-		// используем ErrNoRows как маркер "нельзя перейти"
-		return sql.ErrNoRows
+// Изменение позиций: fn работает в транзакции, затем сумма заказа
+// пересчитывается по позициям. Заказ, успевший уйти из начального статуса,
+// не меняется; как и заказ, сумма которого не сходится с позициями.
+func editOrderItems(orderID string, fn func(tx *sql.Tx, now time.Time) error) error {
+	now := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status, currency string
+	var totalMinor int64
+	if err := tx.QueryRow(`SELECT status, currency, total_minor FROM orders WHERE id = ?`, orderID).
+		Scan(&status, &currency, &totalMinor); err != nil {
+		return err
+	}
+	if OrderStatus(status) != orderWorkflow.Initial {
+		return errOrderNotEditable
+	}
+	if before, err := orderItemsTotal(tx, orderID, currency); err != nil {
+		return err
+	} else if before.Minor != totalMinor {
+		return errOrderItemsUnpriced
+	}
+
+	if err := fn(tx, now); err != nil {
+		return err
+	}
+
+	total, err := orderItemsTotal(tx, orderID, currency)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE orders SET total_minor = ?, updated_at = ? WHERE id = ?`,
+		total.Minor, now, orderID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// сумма позиций заказа по их ценам
+func orderItemsTotal(tx *sql.Tx, orderID, currency string) (Money, error) {
+	rows, err := tx.Query(`SELECT quantity, unit_price_minor FROM order_items WHERE order_id = ?`, orderID)
+	if err != nil {
+		return Money{}, err
+	}
+	defer rows.Close()
+
+	total := Money{Currency: currency}
+	for rows.Next() {
+		var qty int
+		var price int64
+		if err := rows.Scan(&qty, &price); err != nil {
+			return Money{}, err
+		}
+		line, err := Money{Minor: price, Currency: currency}.mul(qty)
+		if err == nil {
+			total, err = total.add(line)
+		}
+		if err != nil {
+			return Money{}, err
+		}
+	}
+	return total, rows.Err()
+}
+
+func addOrderItem(orderID string, it *OrderItem) error {
+	return editOrderItems(orderID, func(tx *sql.Tx, now time.Time) error {
+		var position int
+		if err := tx.QueryRow(`SELECT COALESCE(MAX(position), 0) + 1 FROM order_items WHERE order_id = ?`,
+			orderID).Scan(&position); err != nil {
+			return err
+		}
+		return insertOrderItemTx(tx, orderID, position, it, now)
+	})
+}
+
+// false — позиции с таким id в заказе нет
+func updateOrderItemQuantity(orderID, lineID string, quantity int) (bool, error) {
+	found := false
+	err := editOrderItems(orderID, func(tx *sql.Tx, now time.Time) error {
+		res, err := tx.Exec(`UPDATE order_items SET quantity = ?, updated_at = ? WHERE id = ? AND order_id = ?`,
+			quantity, now, lineID, orderID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		found = n > 0
+		if err == nil && !found {
+			// ничего не изменилось — откатываем, сумму не трогаем
+			err = sql.ErrNoRows
+		}
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return found, err
+}
+
+// false — позиции с таким id в заказе нет
+func deleteOrderItem(orderID, lineID string) (bool, error) {
+	err := editOrderItems(orderID, func(tx *sql.Tx, now time.Time) error {
+		var exists, count int
+		if err := tx.QueryRow(
+			`SELECT COUNT(*), COALESCE(SUM(id = ?), 0) FROM order_items WHERE order_id = ?`,
+			lineID, orderID,
+		).Scan(&count, &exists); err != nil {
+			return err
+		}
+		if exists == 0 {
+			return sql.ErrNoRows
+		}
+		if count == 1 {
+			return errLastOrderItem
+		}
+		_, err := tx.Exec(`DELETE FROM order_items WHERE id = ? AND order_id = ?`, lineID, orderID)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// обновление статуса в БД (и в объекте); реальный переход пишется в журнал
+// (change — кто и почему, From и To заполняются здесь). Переход проверяется
+// по workflow: отказ — *TransitionError. Тот же статус — ничего не меняется.
+func updateOrderStatus(o *Order, newStatus OrderStatus, change *StatusChange) error {
+	if o.Status == newStatus {
+		return nil
+	}
+	if err := orderWorkflow.checkTransition(o.Status, newStatus, change); err != nil {
+		return err
 	}
 
 	now := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// статус могли сменить параллельно — переход проверялся от прежнего
+	res, err := tx.Exec(
+		`UPDATE orders SET status = ?, updated_at = ? WHERE id = ? AND status = ?`,
+		string(newStatus), now, o.ID, string(o.Status),
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &TransitionError{
+			HTTPStatus: http.StatusConflict,
+			Code:       "STATUS_CHANGED",
+			Message:    "Order status was changed concurrently, reload the order",
+		}
+	}
+
+	change.From = o.Status
+	change.To = newStatus
+	change.CreatedAt = now
+	if err := insertStatusChangeTx(tx, o.ID, change); err != nil {
+		return err
+	}
+
+	itemStatus, closeItems := orderWorkflow.itemStatus(newStatus)
+	if closeItems {
+		if _, err := tx.Exec(
+			`UPDATE order_items SET status = ?, updated_at = ? WHERE order_id = ? AND status = ?`,
+			string(itemStatus), now, o.ID, string(ItemPending),
+		); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
 	o.Status = newStatus
 	o.UpdatedAt = now
+	if closeItems {
+		for i := range o.Items {
+			if o.Items[i].Status == ItemPending {
+				o.Items[i].Status = itemStatus
+			}
+		}
+	}
+	return nil
+}
+
+// удаление заказа вместе с позициями и журналом статусов
+func deleteOrder(o *Order) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"order_items", "order_status_history"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE order_id = ?`, o.ID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM orders WHERE id = ?`, o.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// товар каталога
+type Product struct {
+	SKU       string    `json:"sku"`
+	Name      string    `json:"name"`
+	UnitPrice Money     `json:"unitPrice"`
+	Currency  string    `json:"currency"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func scanProduct(row interface{ Scan(...any) error }) (*Product, error) {
+	var p Product
+	var priceMinor int64
+	if err := row.Scan(&p.SKU, &p.Name, &priceMinor, &p.Currency, &p.Active, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	p.UnitPrice = Money{Minor: priceMinor, Currency: p.Currency}
+	return &p, nil
+}
+
+// false — товар с таким SKU уже есть
+func insertProduct(p *Product) (bool, error) {
+	now := time.Now()
+	p.CreatedAt = now
+	p.UpdatedAt = now
+
+	res, err := db.Exec(
+		`INSERT OR IGNORE INTO products (sku, name, unit_price_minor, currency, active, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		p.SKU, p.Name, p.UnitPrice.Minor, p.Currency, p.Active, p.CreatedAt, p.UpdatedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func getProductBySKU(sku string) (*Product, error) {
+	p, err := scanProduct(db.QueryRow(
+		`SELECT sku, name, unit_price_minor, currency, active, created_at, updated_at FROM products WHERE sku = ?`,
+		sku,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+func getProductsCount(includeInactive bool) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM products WHERE active = 1 OR ?`, includeInactive).Scan(&count)
+	return count, err
+}
+
+func listProducts(includeInactive bool, limit, offset int) ([]*Product, error) {
+	rows, err := db.Query(
+		`SELECT sku, name, unit_price_minor, currency, active, created_at, updated_at FROM products
+		 WHERE active = 1 OR ? ORDER BY sku LIMIT ? OFFSET ?`,
+		includeInactive, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := make([]*Product, 0)
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, p)
+	}
+	return products, rows.Err()
+}
+
+func updateProduct(p *Product) error {
+	p.UpdatedAt = time.Now()
 
 	_, err := db.Exec(
-		`UPDATE orders SET status = ?, updated_at = ? WHERE id = ?`,
-		string(o.Status), o.UpdatedAt, o.ID,
+		`UPDATE products SET name = ?, unit_price_minor = ?, currency = ?, active = ?, updated_at = ? WHERE sku = ?`,
+		p.Name, p.UnitPrice.Minor, p.Currency, p.Active, p.UpdatedAt, p.SKU,
 	)
 	return err
 }
 
-// удаление заказа
-func deleteOrder(o *Order) error {
-	_, err := db.Exec(`DELETE FROM orders WHERE id = ?`, o.ID)
-	return err
+// удалить товар из каталога; в уже оформленных заказах позиции остаются как были
+func deleteProduct(sku string) (bool, error) {
+	res, err := db.Exec(`DELETE FROM products WHERE sku = ?`, sku)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
 }
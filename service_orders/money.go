@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Деньги храним целым числом минимальных единиц валюты (центы, копейки) вместе
+// с кодом ISO 4217. В JSON сумма уходит строкой ("12.30"), чтобы клиенты не
+// теряли точность на float.
+type Money struct {
+	Minor    int64
+	Currency string
+}
+
+// число знаков после запятой по ISO 4217 для поддерживаемых валют
+var currencyExponents = map[string]int{
+	"AED": 2, "AMD": 2, "AUD": 2, "AZN": 2, "BHD": 3, "BRL": 2, "BYN": 2, "CAD": 2,
+	"CHF": 2, "CLP": 0, "CNY": 2, "CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2, "GEL": 2,
+	"HKD": 2, "HUF": 2, "IDR": 2, "ILS": 2, "INR": 2, "ISK": 0, "JOD": 3, "JPY": 0,
+	"KGS": 2, "KRW": 0, "KWD": 3, "KZT": 2, "MXN": 2, "NOK": 2, "NZD": 2, "OMR": 3,
+	"PLN": 2, "RSD": 2, "RUB": 2, "SEK": 2, "SGD": 2, "THB": 2, "TND": 3, "TRY": 2,
+	"UAH": 2, "USD": 2, "UZS": 2, "VND": 0, "ZAR": 2,
+}
+
+// не больше 12 цифр в целой части: сумма по заказу гарантированно влезает в int64
+var amountPattern = regexp.MustCompile(`^[0-9]{1,12}(\.[0-9]+)?$`)
+
+var errAmountOverflow = errors.New("amount is too large")
+
+func validCurrency(code string) bool {
+	_, ok := currencyExponents[code]
+	return ok
+}
+
+// код валюты из запроса: пусто — валюта по умолчанию
+func normalizeCurrency(code string) (string, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		code = defaultCurrency
+	}
+	return code, validCurrency(code)
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// разобрать десятичную строку в минимальные единицы валюты; лишние знаки
+// после запятой — ошибка, а не округление
+func parseMoney(s, currency string) (Money, error) {
+	exp, ok := currencyExponents[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("unsupported currency %q", currency)
+	}
+	s = strings.TrimSpace(s)
+	if !amountPattern.MatchString(s) {
+		return Money{}, fmt.Errorf("amount must be a decimal number like \"12.30\"")
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if len(frac) > exp {
+		return Money{}, fmt.Errorf("%s allows at most %d decimal places", currency, exp)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return Money{}, err
+	}
+	var f int64
+	if frac != "" {
+		if f, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return Money{}, err
+		}
+	}
+	return Money{Minor: w*pow10(exp) + f, Currency: currency}, nil
+}
+
+// сумма из старых REAL-колонок (только для миграции)
+func moneyFromFloat(v float64, currency string) Money {
+	return Money{
+		Minor:    int64(math.Round(v * float64(pow10(currencyExponents[currency])))),
+		Currency: currency,
+	}
+}
+
+func (m Money) String() string {
+	exp := currencyExponents[m.Currency]
+	minor := m.Minor
+	sign := ""
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	if exp == 0 {
+		return sign + strconv.FormatInt(minor, 10)
+	}
+	p := pow10(exp)
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/p, exp, minor%p)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// сумма с контролем переполнения
+func (m Money) add(other Money) (Money, error) {
+	if other.Minor > 0 && m.Minor > math.MaxInt64-other.Minor ||
+		other.Minor < 0 && m.Minor < math.MinInt64-other.Minor {
+		return Money{}, errAmountOverflow
+	}
+	return Money{Minor: m.Minor + other.Minor, Currency: m.Currency}, nil
+}
+
+func (m Money) mul(qty int) (Money, error) {
+	if qty > 0 && (m.Minor > math.MaxInt64/int64(qty) || m.Minor < math.MinInt64/int64(qty)) {
+		return Money{}, errAmountOverflow
+	}
+	return Money{Minor: m.Minor * int64(qty), Currency: m.Currency}, nil
+}
+
+// Сумма во входящем JSON: строка "12.30" или число 12.30. Текст сохраняется
+// как есть и разбирается parseMoney, когда известна валюта, — без float64.
+type decimalAmount string
+
+func (d *decimalAmount) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*d = decimalAmount(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("amount must be a string or a number")
+	}
+	*d = decimalAmount(n.String())
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		minor    int64
+		ok       bool
+	}{
+		{"12.30", "USD", 1230, true},
+		{"12.3", "USD", 1230, true},
+		{"12", "USD", 1200, true},
+		{" 0.01 ", "USD", 1, true},
+		{"12.301", "USD", 0, false},
+		// JPY без дробной части
+		{"1500", "JPY", 1500, true},
+		{"1500.0", "JPY", 0, false},
+		// KWD — три знака
+		{"1.234", "KWD", 1234, true},
+		{"1.2", "KWD", 1200, true},
+		{"1.2345", "KWD", 0, false},
+		// отрицательных цен не бывает
+		{"-1.00", "USD", 0, false},
+		{"1e3", "USD", 0, false},
+		{"", "USD", 0, false},
+		{".5", "USD", 0, false},
+		{"999999999999.99", "USD", 99999999999999, true},
+		{"1000000000000", "USD", 0, false},
+		{"1.00", "XXX", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseMoney(tt.in, tt.currency)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseMoney(%q, %s): err=%v, want ok=%v", tt.in, tt.currency, err, tt.ok)
+			continue
+		}
+		if tt.ok && (got.Minor != tt.minor || got.Currency != tt.currency) {
+			t.Errorf("parseMoney(%q, %s) = %d %s, want %d %s", tt.in, tt.currency, got.Minor, got.Currency, tt.minor, tt.currency)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{Money{1230, "USD"}, "12.30"},
+		{Money{5, "USD"}, "0.05"},
+		{Money{0, "USD"}, "0.00"},
+		{Money{-1230, "USD"}, "-12.30"},
+		{Money{-5, "USD"}, "-0.05"},
+		{Money{1500, "JPY"}, "1500"},
+		{Money{-1500, "JPY"}, "-1500"},
+		{Money{1234, "KWD"}, "1.234"},
+		{Money{7, "KWD"}, "0.007"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("%d %s: got %q, want %q", tt.m.Minor, tt.m.Currency, got, tt.want)
+		}
+	}
+}
+
+// String -> parseMoney возвращает ту же сумму
+func TestMoneyRoundTrip(t *testing.T) {
+	for _, m := range []Money{
+		{0, "USD"}, {1, "USD"}, {1230, "USD"}, {99999999999999, "USD"},
+		{0, "JPY"}, {1500, "JPY"},
+		{1, "KWD"}, {1234, "KWD"}, {999999999999999, "KWD"},
+	} {
+		got, err := parseMoney(m.String(), m.Currency)
+		if err != nil || got != m {
+			t.Errorf("%d %s -> %q -> %+v (err %v)", m.Minor, m.Currency, m.String(), got, err)
+		}
+
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `"` + m.String() + `"`; string(data) != want {
+			t.Errorf("json: got %s, want %s", data, want)
+		}
+	}
+}
+
+func TestMoneyOverflow(t *testing.T) {
+	usd := func(minor int64) Money { return Money{Minor: minor, Currency: "USD"} }
+
+	addTests := []struct {
+		a, b     int64
+		overflow bool
+	}{
+		{100, 200, false},
+		{math.MaxInt64 - 1, 1, false},
+		{math.MaxInt64, 1, true},
+		{math.MaxInt64 / 2, math.MaxInt64/2 + 2, true},
+		{-100, 50, false},
+		{math.MinInt64 + 1, -1, false},
+		{math.MinInt64, -1, true},
+	}
+	for _, tt := range addTests {
+		got, err := usd(tt.a).add(usd(tt.b))
+		if tt.overflow {
+			if !errors.Is(err, errAmountOverflow) {
+				t.Errorf("%d + %d: got %d (err %v), want overflow", tt.a, tt.b, got.Minor, err)
+			}
+			continue
+		}
+		if err != nil || got.Minor != tt.a+tt.b {
+			t.Errorf("%d + %d: got %d (err %v)", tt.a, tt.b, got.Minor, err)
+		}
+	}
+
+	mulTests := []struct {
+		a        int64
+		qty      int
+		overflow bool
+	}{
+		{1230, 3, false},
+		{1230, 0, false},
+		{math.MaxInt64 / 2, 2, false},
+		{math.MaxInt64/2 + 1, 2, true},
+		{math.MaxInt64, 1000, true},
+		{-1230, 3, false},
+		{math.MinInt64 / 2, 2, false},
+		{math.MinInt64/2 - 1, 2, true},
+	}
+	for _, tt := range mulTests {
+		got, err := usd(tt.a).mul(tt.qty)
+		if tt.overflow {
+			if !errors.Is(err, errAmountOverflow) {
+				t.Errorf("%d * %d: got %d (err %v), want overflow", tt.a, tt.qty, got.Minor, err)
+			}
+			continue
+		}
+		if err != nil || got.Minor != tt.a*int64(tt.qty) {
+			t.Errorf("%d * %d: got %d (err %v)", tt.a, tt.qty, got.Minor, err)
+		}
+	}
+}
+
+func TestDecimalAmountJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want decimalAmount
+		ok   bool
+	}{
+		{`"12.30"`, "12.30", true},
+		// число не проходит через float64: все знаки сохраняются
+		{`12.30`, "12.30", true},
+		{`0.1`, "0.1", true},
+		{`123456789012.99`, "123456789012.99", true},
+		{`null`, "", true},
+		{`true`, "", false},
+		{`{"v":1}`, "", false},
+	}
+	for _, tt := range tests {
+		var d decimalAmount
+		err := json.Unmarshal([]byte(tt.in), &d)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err=%v, want ok=%v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && d != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.in, d, tt.want)
+		}
+	}
+
+	// число и строка дают одну и ту же сумму
+	var req struct {
+		Price decimalAmount `json:"price"`
+	}
+	for _, body := range []string{`{"price": 0.07}`, `{"price": "0.07"}`} {
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatal(err)
+		}
+		if m, err := parseMoney(string(req.Price), "USD"); err != nil || m.Minor != 7 {
+			t.Errorf("%s: got %d (err %v), want 7", body, m.Minor, err)
+		}
+	}
+}
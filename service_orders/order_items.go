@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UpdateOrderItemRequest struct {
+	Quantity int `json:"quantity" binding:"required,gt=0,lte=10000"`
+}
+
+// заказ, позиции которого можно менять: существует, действие edit разрешено
+// правилами и заказ ещё в начальном статусе. nil — ответ уже отправлен.
+func loadEditableOrder(c *gin.Context) *Order {
+	order, err := getOrderByID(c.Param("id"))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to get order")
+		return nil
+	}
+	if order == nil {
+		fail(c, http.StatusNotFound, "ORDER_NOT_FOUND", "Order not found")
+		return nil
+	}
+
+	if !authorize(c, actionEdit, order) {
+		return nil
+	}
+	// правила можно переопределить, а это ограничение — нет
+	if order.Status != orderWorkflow.Initial {
+		fail(c, http.StatusConflict, "ORDER_NOT_EDITABLE", "Items can only be changed while the order is "+string(orderWorkflow.Initial))
+		return nil
+	}
+	return order
+}
+
+// ошибка изменения позиций; false — ошибки не было
+func failOrderItemsEdit(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, errOrderNotEditable):
+		fail(c, http.StatusConflict, "ORDER_NOT_EDITABLE", "Items can only be changed while the order is "+string(orderWorkflow.Initial))
+	case errors.Is(err, errOrderItemsUnpriced):
+		fail(c, http.StatusConflict, "ORDER_ITEMS_UNPRICED", "Order was created before per-item prices, its items cannot be changed")
+	case errors.Is(err, errLastOrderItem):
+		fail(c, http.StatusConflict, "LAST_ITEM", "Order must keep at least one item, cancel the order instead")
+	case errors.Is(err, errAmountOverflow):
+		fail(c, http.StatusBadRequest, "AMOUNT_TOO_LARGE", "Order total is too large")
+	default:
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to update order items")
+	}
+	return true
+}
+
+// заказ после изменения позиций: перечитываем, чтобы отдать пересчитанную сумму
+func respondEditedOrder(c *gin.Context, orderID string) {
+	order, err := getOrderByID(orderID)
+	if err != nil || order == nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to get order")
+		return
+	}
+
+	publishOrderItemsUpdated(order, getRequestID(c))
+
+	success(c, order)
+}
+
+// POST /v1/orders/:id/items — цена берётся из каталога, в валюте заказа
+func handleAddOrderItem(c *gin.Context) {
+	var req OrderItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	order := loadEditableOrder(c)
+	if order == nil {
+		return
+	}
+
+	items, _, ok := priceOrderItems(c, []OrderItemRequest{req}, order.Currency)
+	if !ok {
+		return
+	}
+
+	if failOrderItemsEdit(c, addOrderItem(order.ID, &items[0])) {
+		return
+	}
+
+	respondEditedOrder(c, order.ID)
+}
+
+// PATCH /v1/orders/:id/items/:lineId — меняется только количество, цена остаётся прежней
+func handleUpdateOrderItem(c *gin.Context) {
+	var req UpdateOrderItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	order := loadEditableOrder(c)
+	if order == nil {
+		return
+	}
+
+	found, err := updateOrderItemQuantity(order.ID, c.Param("lineId"), req.Quantity)
+	if failOrderItemsEdit(c, err) {
+		return
+	}
+	if !found {
+		fail(c, http.StatusNotFound, "ITEM_NOT_FOUND", "Order item not found")
+		return
+	}
+
+	respondEditedOrder(c, order.ID)
+}
+
+// DELETE /v1/orders/:id/items/:lineId
+func handleDeleteOrderItem(c *gin.Context) {
+	order := loadEditableOrder(c)
+	if order == nil {
+		return
+	}
+
+	found, err := deleteOrderItem(order.ID, c.Param("lineId"))
+	if failOrderItemsEdit(c, err) {
+		return
+	}
+	if !found {
+		fail(c, http.StatusNotFound, "ITEM_NOT_FOUND", "Order item not found")
+		return
+	}
+
+	respondEditedOrder(c, order.ID)
+}
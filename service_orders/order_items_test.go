@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// БД в схеме до order_items: позиции JSON-ом без цен, сумма REAL
+func openLegacyTestDB(t *testing.T) string {
+	t.Helper()
+	loadTestWorkflow(t)
+	defaultCurrency = "USD"
+	// dbPath — относительный путь, БД создаётся во временном каталоге
+	t.Chdir(t.TempDir())
+
+	d, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	now := time.Now()
+	orderID := uuid.NewString()
+	if _, err := d.Exec(`
+	CREATE TABLE orders (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		items_json TEXT NOT NULL,
+		status TEXT NOT NULL,
+		total_amount REAL NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Exec(`INSERT INTO orders VALUES (?, 'u1', ?, 'created', 19.99, ?, ?)`,
+		orderID, `[{"product":"Widget","quantity":2}]`, now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := initDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return orderID
+}
+
+// позиции без цены не пересчитываются в нулевую сумму
+func TestEditLegacyOrderItemsKeepsTotal(t *testing.T) {
+	orderID := openLegacyTestDB(t)
+
+	order, err := getOrderByID(orderID)
+	if err != nil || order == nil {
+		t.Fatalf("get order: %v", err)
+	}
+	if order.TotalAmount.Minor != 1999 || len(order.Items) != 1 {
+		t.Fatalf("got total %s with %d items after migration, want 19.99 with 1", order.TotalAmount, len(order.Items))
+	}
+
+	lineID := order.Items[0].ID
+	if _, err := updateOrderItemQuantity(orderID, lineID, 3); !errors.Is(err, errOrderItemsUnpriced) {
+		t.Errorf("update: got %v, want errOrderItemsUnpriced", err)
+	}
+	item := &OrderItem{SKU: "BOLT", Product: "Bolt", Quantity: 1, UnitPrice: Money{Minor: 10, Currency: "USD"}}
+	if err := addOrderItem(orderID, item); !errors.Is(err, errOrderItemsUnpriced) {
+		t.Errorf("add: got %v, want errOrderItemsUnpriced", err)
+	}
+	if _, err := deleteOrderItem(orderID, lineID); !errors.Is(err, errOrderItemsUnpriced) {
+		t.Errorf("delete: got %v, want errOrderItemsUnpriced", err)
+	}
+
+	order, err = getOrderByID(orderID)
+	if err != nil || order == nil {
+		t.Fatalf("get order: %v", err)
+	}
+	if order.TotalAmount.Minor != 1999 || len(order.Items) != 1 || order.Items[0].Quantity != 2 {
+		t.Errorf("got total %s, %d items, want the order unchanged", order.TotalAmount, len(order.Items))
+	}
+}
+
+func TestEditPricedOrderItemsRecalculatesTotal(t *testing.T) {
+	openLegacyTestDB(t)
+
+	usd := func(minor int64) Money { return Money{Minor: minor, Currency: "USD"} }
+	order := &Order{
+		ID:          uuid.NewString(),
+		UserID:      "u1",
+		Status:      orderWorkflow.Initial,
+		Currency:    "USD",
+		TotalAmount: usd(30),
+		Items:       []OrderItem{{SKU: "BOLT", Product: "Bolt", Quantity: 3, UnitPrice: usd(10)}},
+	}
+	if err := insertOrder(order, &StatusChange{ID: uuid.NewString(), ActorID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addOrderItem(order.ID, &OrderItem{SKU: "NUT", Product: "Nut", Quantity: 4, UnitPrice: usd(25)}); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := updateOrderItemQuantity(order.ID, order.Items[0].ID, 10); err != nil || !found {
+		t.Fatalf("update: found=%v err=%v", found, err)
+	}
+
+	got, err := getOrderByID(order.ID)
+	if err != nil || got == nil {
+		t.Fatalf("get order: %v", err)
+	}
+	if got.TotalAmount.Minor != 10*10+4*25 {
+		t.Errorf("got total %s, want 2.00", got.TotalAmount)
+	}
+}
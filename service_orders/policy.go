@@ -0,0 +1,227 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+)
+
+// действия над заказом, на которые пишутся правила
+const (
+	actionCreate = "create"
+	actionRead   = "read"
+	actionUpdate = "update"
+	actionCancel = "cancel"
+	actionDelete = "delete"
+	actionEdit   = "edit" // изменение позиций заказа
+)
+
+var policyActions = map[string]bool{
+	actionCreate: true,
+	actionRead:   true,
+	actionUpdate: true,
+	actionCancel: true,
+	actionDelete: true,
+	actionEdit:   true,
+}
+
+const (
+	effectAllow = "allow"
+	effectDeny  = "deny"
+)
+
+// правила по умолчанию; ORDERS_POLICY_FILE подменяет их своим файлом
+//
+//go:embed policy.yaml
+var defaultPolicy []byte
+
+// одно правило из policy.yaml; пустое условие не проверяется
+type PolicyRule struct {
+	Name        string        `yaml:"name"`
+	Action      string        `yaml:"action"`
+	Effect      string        `yaml:"effect"`      // allow (по умолчанию) / deny
+	Roles       []string      `yaml:"roles"`       // хотя бы одна из ролей
+	Permissions []string      `yaml:"permissions"` // хотя бы одно из прав
+	Owner       *bool         `yaml:"owner"`       // true — свой заказ, false — чужой
+	Status      []OrderStatus `yaml:"status"`      // допустимые статусы заказа
+}
+
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+// кто выполняет действие
+type PolicySubject struct {
+	UserID      string
+	Roles       []string
+	Permissions []string
+}
+
+// атрибуты заказа, от которых зависит решение
+type PolicyResource struct {
+	OwnerID string
+	Status  OrderStatus
+}
+
+type PolicyDecision struct {
+	Allow  bool
+	Reason string
+}
+
+var orderPolicy *Policy
+
+func initPolicy() error {
+	path, data := "embedded policy.yaml", defaultPolicy
+	if ordersPolicyPath != "" {
+		var err error
+		if data, err = os.ReadFile(ordersPolicyPath); err != nil {
+			return err
+		}
+		path = ordersPolicyPath
+	}
+
+	p, err := parsePolicy(path, data)
+	if err != nil {
+		return err
+	}
+	orderPolicy = p
+
+	log.Printf("Orders policy loaded from %s: %d rules", path, len(p.Rules))
+	return nil
+}
+
+// разбираем правила (path нужен только для сообщений об ошибках)
+func parsePolicy(path string, data []byte) (*Policy, error) {
+	var p Policy
+	if err := yaml.UnmarshalWithOptions(data, &p, yaml.DisallowUnknownField()); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &p, nil
+}
+
+func (p *Policy) validate() error {
+	if len(p.Rules) == 0 {
+		return fmt.Errorf("no rules defined")
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("#%d", i+1)
+		}
+		if !policyActions[r.Action] {
+			return fmt.Errorf("rule %s: unknown action %q", r.Name, r.Action)
+		}
+		if r.Effect == "" {
+			r.Effect = effectAllow
+		}
+		if r.Effect != effectAllow && r.Effect != effectDeny {
+			return fmt.Errorf("rule %s: effect must be allow or deny", r.Name)
+		}
+		// правило без условий на субъекта открыло бы действие всем
+		if len(r.Roles) == 0 && len(r.Permissions) == 0 {
+			return fmt.Errorf("rule %s: roles or permissions required", r.Name)
+		}
+		for _, s := range r.Status {
+			if _, ok := orderWorkflow.status(string(s)); !ok {
+				return fmt.Errorf("rule %s: unknown status %q", r.Name, s)
+			}
+		}
+	}
+	return nil
+}
+
+func containsAny(have, want []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// подходит ли правило; если субъект подходит, а заказ нет — причина отказа
+func (r *PolicyRule) match(s PolicySubject, action string, res PolicyResource) (bool, string) {
+	if r.Action != action {
+		return false, ""
+	}
+	if len(r.Roles) > 0 && !containsAny(s.Roles, r.Roles) {
+		return false, ""
+	}
+	if len(r.Permissions) > 0 && !containsAny(s.Permissions, r.Permissions) {
+		return false, ""
+	}
+	if r.Owner != nil && *r.Owner != (res.OwnerID == s.UserID) {
+		if *r.Owner {
+			return false, "order belongs to another user"
+		}
+		return false, "rule applies only to other users' orders"
+	}
+	if len(r.Status) > 0 {
+		ok := false
+		for _, st := range r.Status {
+			if st == res.Status {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false, fmt.Sprintf("action is not allowed for orders in status '%s'", res.Status)
+		}
+	}
+	return true, ""
+}
+
+// решение по правилам: первое подошедшее правило, иначе отказ
+func (p *Policy) evaluate(s PolicySubject, action string, res PolicyResource) PolicyDecision {
+	reason := ""
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		ok, why := r.match(s, action, res)
+		if !ok {
+			// неподошедшее deny-правило ничего не запрещало — его причина не нужна
+			if reason == "" && r.Effect == effectAllow {
+				reason = why
+			}
+			continue
+		}
+		if r.Effect == effectDeny {
+			return PolicyDecision{Allow: false, Reason: "denied by rule " + r.Name}
+		}
+		return PolicyDecision{Allow: true, Reason: "allowed by rule " + r.Name}
+	}
+	if reason == "" {
+		reason = "no permission to " + action + " orders"
+	}
+	return PolicyDecision{Allow: false, Reason: reason}
+}
+
+// можно ли текущему пользователю выполнить action над заказом.
+// При отказе ответ 403 уже отправлен.
+func authorize(c *gin.Context, action string, order *Order) bool {
+	userID, _ := getUserID(c)
+	subject := PolicySubject{
+		UserID:      userID,
+		Roles:       getRoles(c),
+		Permissions: getPermissions(c),
+	}
+	resource := PolicyResource{OwnerID: order.UserID, Status: order.Status}
+
+	d := orderPolicy.evaluate(subject, action, resource)
+	if !d.Allow {
+		log.Printf("requestId=%s policy deny userId=%s action=%s order=%s: %s",
+			getRequestID(c), userID, action, order.ID, d.Reason)
+		fail(c, http.StatusForbidden, "FORBIDDEN", "Not allowed to "+action+" this order: "+d.Reason)
+		return false
+	}
+	return true
+}
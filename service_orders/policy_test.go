@@ -0,0 +1,179 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// правила проверяют статусы по жизненному циклу, поэтому он нужен до parsePolicy
+func loadTestWorkflow(t *testing.T) {
+	t.Helper()
+	w, err := parseWorkflow("workflow.yaml", defaultWorkflow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderWorkflow = w
+}
+
+func mustParsePolicy(t *testing.T, data string) *Policy {
+	t.Helper()
+	p, err := parsePolicy("test", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// встроенный policy.yaml: действие × права × владелец × статус
+func TestDefaultPolicyTruthTable(t *testing.T) {
+	loadTestWorkflow(t)
+	p := mustParsePolicy(t, string(defaultPolicy))
+
+	customer := []string{"orders:create", "orders:read:own", "orders:update:own", "orders:cancel:own", "orders:delete:own"}
+	manager := []string{"orders:create", "orders:read:any", "orders:update:any", "orders:cancel:any", "orders:delete:any"}
+
+	tests := []struct {
+		name   string
+		perms  []string
+		action string
+		own    bool
+		status OrderStatus
+		allow  bool
+		reason string
+	}{
+		{"customer creates", customer, actionCreate, true, "", true, "create"},
+		{"no permissions create", nil, actionCreate, true, "", false, "no permission to create orders"},
+
+		{"customer reads own", customer, actionRead, true, "done", true, "read-own"},
+		{"customer reads foreign", customer, actionRead, false, "done", false, "order belongs to another user"},
+		{"manager reads foreign", manager, actionRead, false, "created", true, "read-any"},
+		{"no permissions read", nil, actionRead, true, "created", false, "no permission to read orders"},
+
+		{"customer updates own", customer, actionUpdate, true, "in_progress", true, "update-own"},
+		{"customer updates foreign", customer, actionUpdate, false, "in_progress", false, "order belongs to another user"},
+		{"manager updates foreign", manager, actionUpdate, false, "in_progress", true, "update-any"},
+
+		{"customer cancels own", customer, actionCancel, true, "created", true, "cancel-own"},
+		{"customer cancels foreign", customer, actionCancel, false, "created", false, "order belongs to another user"},
+		{"manager cancels foreign", manager, actionCancel, false, "in_progress", true, "cancel-any"},
+
+		{"customer deletes own created", customer, actionDelete, true, "created", true, "delete-own"},
+		{"customer deletes own cancelled", customer, actionDelete, true, "cancelled", true, "delete-own"},
+		{"customer deletes own in progress", customer, actionDelete, true, "in_progress", false, "status 'in_progress'"},
+		{"customer deletes own done", customer, actionDelete, true, "done", false, "status 'done'"},
+		{"customer deletes foreign", customer, actionDelete, false, "created", false, "order belongs to another user"},
+		{"manager deletes foreign done", manager, actionDelete, false, "done", true, "delete-any"},
+
+		{"customer edits own created", customer, actionEdit, true, "created", true, "edit-own"},
+		{"customer edits own in progress", customer, actionEdit, true, "in_progress", false, "status 'in_progress'"},
+		{"customer edits foreign", customer, actionEdit, false, "created", false, "order belongs to another user"},
+		{"manager edits foreign created", manager, actionEdit, false, "created", true, "edit-any"},
+		{"manager edits foreign done", manager, actionEdit, false, "done", false, "status 'done'"},
+
+		{"unknown action", manager, "archive", true, "created", false, "no permission to archive orders"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := PolicySubject{UserID: "u1", Roles: []string{"customer"}, Permissions: tt.perms}
+			res := PolicyResource{OwnerID: "u2", Status: tt.status}
+			if tt.own {
+				res.OwnerID = s.UserID
+			}
+
+			d := p.evaluate(s, tt.action, res)
+			if d.Allow != tt.allow || !strings.Contains(d.Reason, tt.reason) {
+				t.Errorf("got allow=%v reason %q, want allow=%v reason containing %q", d.Allow, d.Reason, tt.allow, tt.reason)
+			}
+		})
+	}
+}
+
+// роли, deny и порядок правил
+func TestPolicyRolesAndDeny(t *testing.T) {
+	loadTestWorkflow(t)
+	p := mustParsePolicy(t, `
+rules:
+  - name: no-cancel-in-progress
+    action: cancel
+    effect: deny
+    roles: [customer]
+    status: [in_progress]
+  - name: customer-cancels-own
+    action: cancel
+    roles: [customer]
+    owner: true
+  - name: support-cancels-foreign
+    action: cancel
+    roles: [support]
+    owner: false
+`)
+
+	tests := []struct {
+		name   string
+		roles  []string
+		own    bool
+		status OrderStatus
+		allow  bool
+		reason string
+	}{
+		{"customer own created", []string{"customer"}, true, "created", true, "allowed by rule customer-cancels-own"},
+		{"customer own in progress", []string{"customer"}, true, "in_progress", false, "denied by rule no-cancel-in-progress"},
+		{"customer foreign created", []string{"customer"}, false, "created", false, "order belongs to another user"},
+		{"support foreign", []string{"support"}, false, "in_progress", true, "allowed by rule support-cancels-foreign"},
+		{"support own", []string{"support"}, true, "created", false, "rule applies only to other users' orders"},
+		{"both roles own in progress", []string{"support", "customer"}, true, "in_progress", false, "denied by rule no-cancel-in-progress"},
+		{"no roles", nil, true, "created", false, "no permission to cancel orders"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := PolicySubject{UserID: "u1", Roles: tt.roles}
+			res := PolicyResource{OwnerID: "u2", Status: tt.status}
+			if tt.own {
+				res.OwnerID = s.UserID
+			}
+
+			d := p.evaluate(s, actionCancel, res)
+			if d.Allow != tt.allow || d.Reason != tt.reason {
+				t.Errorf("got allow=%v reason %q, want allow=%v reason %q", d.Allow, d.Reason, tt.allow, tt.reason)
+			}
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	loadTestWorkflow(t)
+
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"empty", `rules: []`, "no rules defined"},
+		{"unknown action", "rules:\n  - action: archive\n    roles: [admin]", `unknown action "archive"`},
+		{"bad effect", "rules:\n  - action: read\n    effect: maybe\n    roles: [admin]", "effect must be allow or deny"},
+		{"no subject", "rules:\n  - name: open\n    action: read", "rule open: roles or permissions required"},
+		{"unknown status", "rules:\n  - action: delete\n    roles: [admin]\n    status: [archived]", `unknown status "archived"`},
+		{"unknown field", "rules:\n  - action: read\n    roles: [admin]\n    group: x", "parse test"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePolicy("test", []byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// безымянные правила получают номер, effect по умолчанию — allow
+func TestPolicyValidateDefaults(t *testing.T) {
+	loadTestWorkflow(t)
+	p := mustParsePolicy(t, "rules:\n  - action: read\n    roles: [admin]")
+
+	if r := p.Rules[0]; r.Name != "#1" || r.Effect != effectAllow {
+		t.Errorf("got name %q effect %q, want #1 allow", r.Name, r.Effect)
+	}
+}
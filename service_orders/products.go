@@ -0,0 +1,261 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// право на управление каталогом (выдаётся ролям в service_users)
+const permProductsManage = "products:manage"
+
+var skuPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+type CreateProductRequest struct {
+	SKU       string        `json:"sku" binding:"required"`
+	Name      string        `json:"name" binding:"required,max=200"`
+	UnitPrice decimalAmount `json:"unitPrice" binding:"required"` // "12.30"
+	Currency  string        `json:"currency"`                     // ISO 4217, по умолчанию DEFAULT_CURRENCY
+	Active    *bool         `json:"active"`                       // по умолчанию true
+}
+
+// новая валюта без новой цены не принимается: цена в старой валюте потеряла бы смысл
+type UpdateProductRequest struct {
+	Name      *string        `json:"name" binding:"omitempty,max=200"`
+	UnitPrice *decimalAmount `json:"unitPrice"`
+	Currency  *string        `json:"currency"`
+	Active    *bool          `json:"active"`
+}
+
+// цена товара: положительная сумма в поддерживаемой валюте; false — ответ уже отправлен
+func parseUnitPrice(c *gin.Context, amount decimalAmount, currency string) (Money, bool) {
+	code, ok := normalizeCurrency(currency)
+	if !ok {
+		fail(c, http.StatusBadRequest, "INVALID_CURRENCY", "Unsupported currency: "+code)
+		return Money{}, false
+	}
+	price, err := parseMoney(string(amount), code)
+	if err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "unitPrice: "+err.Error())
+		return Money{}, false
+	}
+	if price.Minor <= 0 {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "unitPrice must be positive")
+		return Money{}, false
+	}
+	return price, true
+}
+
+// позиции заказа по каталогу: цена и название берутся из products.
+// Все товары должны быть в одной валюте — в currency, если её передали,
+// иначе в валюте первого товара. false — ответ с ошибкой уже отправлен.
+func priceOrderItems(c *gin.Context, reqItems []OrderItemRequest, currency string) ([]OrderItem, Money, bool) {
+	items := make([]OrderItem, 0, len(reqItems))
+	var total Money
+	for _, it := range reqItems {
+		sku := strings.TrimSpace(it.SKU)
+		if sku == "" || it.Quantity <= 0 {
+			fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "Invalid item sku or quantity")
+			return nil, Money{}, false
+		}
+
+		p, err := getProductBySKU(sku)
+		if err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to get product")
+			return nil, Money{}, false
+		}
+		if p == nil || !p.Active {
+			failWithDetails(c, http.StatusBadRequest, "UNKNOWN_PRODUCT", "Product "+sku+" is not in the catalog or not available",
+				gin.H{"sku": sku})
+			return nil, Money{}, false
+		}
+
+		if currency == "" {
+			currency = p.Currency
+		}
+		if p.Currency != currency {
+			failWithDetails(c, http.StatusBadRequest, "CURRENCY_MISMATCH", "Product "+sku+" is priced in "+p.Currency+", order currency is "+currency,
+				gin.H{"sku": sku, "productCurrency": p.Currency, "currency": currency})
+			return nil, Money{}, false
+		}
+		if total.Currency == "" {
+			total.Currency = currency
+		}
+
+		line, err := p.UnitPrice.mul(it.Quantity)
+		if err == nil {
+			total, err = total.add(line)
+		}
+		if err != nil {
+			fail(c, http.StatusBadRequest, "AMOUNT_TOO_LARGE", "Order total is too large")
+			return nil, Money{}, false
+		}
+
+		items = append(items, OrderItem{
+			SKU:       p.SKU,
+			Product:   p.Name,
+			Quantity:  it.Quantity,
+			UnitPrice: p.UnitPrice,
+		})
+	}
+	return items, total, true
+}
+
+// POST /v1/products (products:manage)
+func handleCreateProduct(c *gin.Context) {
+	var req CreateProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+	req.SKU = strings.TrimSpace(req.SKU)
+	req.Name = strings.TrimSpace(req.Name)
+	if !skuPattern.MatchString(req.SKU) {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "sku must be 1-64 letters, digits, '.', '_' or '-'")
+		return
+	}
+	if req.Name == "" {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "name is required")
+		return
+	}
+
+	price, ok := parseUnitPrice(c, req.UnitPrice, req.Currency)
+	if !ok {
+		return
+	}
+
+	p := &Product{
+		SKU:       req.SKU,
+		Name:      req.Name,
+		UnitPrice: price,
+		Currency:  price.Currency,
+		Active:    req.Active == nil || *req.Active,
+	}
+
+	created, err := insertProduct(p)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to create product")
+		return
+	}
+	if !created {
+		fail(c, http.StatusConflict, "PRODUCT_EXISTS", "Product with this sku already exists")
+		return
+	}
+
+	success(c, p)
+}
+
+// GET /v1/products?page=&limit=&all=true — all (с неактивными) только для products:manage
+func handleListProducts(c *gin.Context) {
+	page, limit, offset := pagination(c)
+	includeInactive := c.Query("all") == "true" && hasPermission(c, permProductsManage)
+
+	total, err := getProductsCount(includeInactive)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to count products")
+		return
+	}
+	products, err := listProducts(includeInactive, limit, offset)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to list products")
+		return
+	}
+
+	success(c, gin.H{
+		"items": products,
+		"page":  page,
+		"limit": limit,
+		"total": total,
+	})
+}
+
+// GET /v1/products/:sku — неактивный товар видят только с products:manage
+func handleGetProduct(c *gin.Context) {
+	p, err := getProductBySKU(c.Param("sku"))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to get product")
+		return
+	}
+	if p == nil || (!p.Active && !hasPermission(c, permProductsManage)) {
+		fail(c, http.StatusNotFound, "PRODUCT_NOT_FOUND", "Product not found")
+		return
+	}
+
+	success(c, p)
+}
+
+// PATCH /v1/products/:sku (products:manage) — новая цена действует только на новые заказы
+func handleUpdateProduct(c *gin.Context) {
+	var req UpdateProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	p, err := getProductBySKU(c.Param("sku"))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to get product")
+		return
+	}
+	if p == nil {
+		fail(c, http.StatusNotFound, "PRODUCT_NOT_FOUND", "Product not found")
+		return
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "name must not be empty")
+			return
+		}
+		p.Name = name
+	}
+	if req.Currency != nil && req.UnitPrice == nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "unitPrice is required when changing currency")
+		return
+	}
+	if req.UnitPrice != nil {
+		currency := p.Currency
+		if req.Currency != nil {
+			currency = *req.Currency
+		}
+		price, ok := parseUnitPrice(c, *req.UnitPrice, currency)
+		if !ok {
+			return
+		}
+		p.UnitPrice = price
+		p.Currency = price.Currency
+	}
+	if req.Active != nil {
+		p.Active = *req.Active
+	}
+
+	if err := updateProduct(p); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to update product")
+		return
+	}
+
+	success(c, p)
+}
+
+// DELETE /v1/products/:sku (products:manage)
+func handleDeleteProduct(c *gin.Context) {
+	sku := c.Param("sku")
+
+	ok, err := deleteProduct(sku)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to delete product")
+		return
+	}
+	if !ok {
+		fail(c, http.StatusNotFound, "PRODUCT_NOT_FOUND", "Product not found")
+		return
+	}
+
+	success(c, gin.H{
+		"sku":     sku,
+		"deleted": true,
+	})
+}
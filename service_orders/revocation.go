@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// локальный кэш денылиста service_users: синхронизируется опросом
+// GET /internal/revocations и сразу — по push-уведомлению
+type revocationCache struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time // jti → срок действия токена
+	users  map[string]time.Time // userId → отозваны токены, выпущенные раньше
+	cursor string               // since для следующего опроса
+}
+
+var (
+	revocations = &revocationCache{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]time.Time),
+	}
+	revocationNotify = make(chan struct{}, 1)
+	revocationClient = &http.Client{Timeout: 5 * time.Second}
+)
+
+func (r *revocationCache) isRevoked(claims *UserClaims) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.tokens[claims.ID]; ok && claims.ID != "" {
+		return true
+	}
+	if before, ok := r.users[claims.UserID]; ok {
+		return claims.IssuedAt == nil || claims.IssuedAt.Time.Before(before)
+	}
+	return false
+}
+
+func syncRevocations() error {
+	revocations.mu.RLock()
+	cursor := revocations.cursor
+	revocations.mu.RUnlock()
+
+	req, err := http.NewRequest(http.MethodGet, revocationsURL+"?since="+url.QueryEscape(cursor), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Internal-Token", string(internalAuthSecret))
+
+	resp, err := revocationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data struct {
+			Tokens []struct {
+				JTI       string    `json:"jti"`
+				ExpiresAt time.Time `json:"expiresAt"`
+			} `json:"tokens"`
+			Users []struct {
+				UserID        string    `json:"userId"`
+				RevokedBefore time.Time `json:"revokedBefore"`
+			} `json:"users"`
+			Now string `json:"now"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	revocations.mu.Lock()
+	defer revocations.mu.Unlock()
+
+	for _, t := range body.Data.Tokens {
+		revocations.tokens[t.JTI] = t.ExpiresAt
+	}
+	for _, u := range body.Data.Users {
+		revocations.users[u.UserID] = u.RevokedBefore
+	}
+	for jti, exp := range revocations.tokens {
+		if exp.Before(now) {
+			delete(revocations.tokens, jti)
+		}
+	}
+	revocations.cursor = body.Data.Now
+	return nil
+}
+
+func runRevocationSync(interval time.Duration) {
+	if len(internalAuthSecret) == 0 {
+		log.Println("revocation sync disabled: INTERNAL_AUTH_SECRET is not set")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := syncRevocations(); err != nil {
+			log.Printf("revocation sync from %s failed: %v", revocationsURL, err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-revocationNotify:
+		}
+	}
+}
+
+func InternalRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader("X-Internal-Token")
+		if len(internalAuthSecret) == 0 || !hmac.Equal([]byte(token), internalAuthSecret) {
+			fail(c, http.StatusForbidden, "FORBIDDEN", "Internal token required")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// POST /internal/revocations/notify — service_users сообщает об отзыве
+func handleRevocationNotify(c *gin.Context) {
+	select {
+	case revocationNotify <- struct{}{}:
+	default:
+	}
+	c.Status(http.StatusAccepted)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+)
+
+// жизненный цикл по умолчанию; ORDERS_WORKFLOW_FILE подменяет его своим файлом
+//
+//go:embed workflow.yaml
+var defaultWorkflow []byte
+
+// поля запроса, которые переход может сделать обязательными
+const fieldComment = "comment"
+
+var statusNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+type WorkflowStatus struct {
+	Name     string          `yaml:"name" json:"name"`
+	Terminal bool            `yaml:"terminal" json:"terminal"`
+	Items    OrderItemStatus `yaml:"items" json:"items,omitempty"` // что станет с открытыми позициями
+}
+
+type WorkflowTransition struct {
+	From        []OrderStatus `yaml:"from" json:"from"`
+	To          OrderStatus   `yaml:"to" json:"to"`
+	Roles       []string      `yaml:"roles" json:"roles,omitempty"`             // хотя бы одна из ролей
+	Permissions []string      `yaml:"permissions" json:"permissions,omitempty"` // хотя бы одно из прав (у API-ключа урезаны до scopes)
+	Require     []string      `yaml:"require" json:"require,omitempty"`         // обязательные поля запроса
+}
+
+type Workflow struct {
+	Initial     OrderStatus          `yaml:"initial" json:"initial"`
+	Cancel      OrderStatus          `yaml:"cancel" json:"cancel"`
+	Statuses    []WorkflowStatus     `yaml:"statuses" json:"statuses"`
+	Transitions []WorkflowTransition `yaml:"transitions" json:"transitions"`
+
+	statuses    map[OrderStatus]*WorkflowStatus
+	transitions map[OrderStatus]map[OrderStatus]*WorkflowTransition
+}
+
+// отказ в смене статуса; code и status уходят клиенту
+type TransitionError struct {
+	HTTPStatus int
+	Code       string
+	Message    string
+}
+
+func (e *TransitionError) Error() string {
+	return e.Message
+}
+
+var orderWorkflow *Workflow
+
+func initWorkflow() error {
+	path, data := "embedded workflow.yaml", defaultWorkflow
+	if ordersWorkflowPath != "" {
+		var err error
+		if data, err = os.ReadFile(ordersWorkflowPath); err != nil {
+			return err
+		}
+		path = ordersWorkflowPath
+	}
+
+	w, err := parseWorkflow(path, data)
+	if err != nil {
+		return err
+	}
+	orderWorkflow = w
+
+	log.Printf("Orders workflow loaded from %s: %d statuses, %d transitions", path, len(w.Statuses), len(w.Transitions))
+	return nil
+}
+
+// разбираем и проверяем (path нужен только для сообщений об ошибках)
+func parseWorkflow(path string, data []byte) (*Workflow, error) {
+	var w Workflow
+	if err := yaml.UnmarshalWithOptions(data, &w, yaml.DisallowUnknownField()); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := w.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &w, nil
+}
+
+func (w *Workflow) validate() error {
+	if len(w.Statuses) == 0 {
+		return fmt.Errorf("no statuses defined")
+	}
+
+	w.statuses = make(map[OrderStatus]*WorkflowStatus, len(w.Statuses))
+	terminals := 0
+	for i := range w.Statuses {
+		s := &w.Statuses[i]
+		if !statusNamePattern.MatchString(s.Name) {
+			return fmt.Errorf("status %q: name must be lowercase letters, digits or '_'", s.Name)
+		}
+		if _, dup := w.statuses[OrderStatus(s.Name)]; dup {
+			return fmt.Errorf("status %s is defined twice", s.Name)
+		}
+		switch s.Items {
+		case "", ItemFulfilled, ItemCancelled:
+		default:
+			return fmt.Errorf("status %s: items must be %s or %s", s.Name, ItemFulfilled, ItemCancelled)
+		}
+		if s.Terminal {
+			terminals++
+		}
+		w.statuses[OrderStatus(s.Name)] = s
+	}
+	if terminals == 0 {
+		return fmt.Errorf("at least one terminal status is required")
+	}
+
+	if st, ok := w.statuses[w.Initial]; !ok {
+		return fmt.Errorf("initial status %q is not defined", w.Initial)
+	} else if st.Terminal {
+		return fmt.Errorf("initial status %s must not be terminal", w.Initial)
+	}
+	if st, ok := w.statuses[w.Cancel]; !ok {
+		return fmt.Errorf("cancel status %q is not defined", w.Cancel)
+	} else if !st.Terminal {
+		return fmt.Errorf("cancel status %s must be terminal", w.Cancel)
+	}
+
+	w.transitions = make(map[OrderStatus]map[OrderStatus]*WorkflowTransition)
+	for i := range w.Transitions {
+		t := &w.Transitions[i]
+		if _, ok := w.statuses[t.To]; !ok {
+			return fmt.Errorf("transition #%d: unknown status %q", i+1, t.To)
+		}
+		if len(t.From) == 0 {
+			return fmt.Errorf("transition #%d to %s: from is required", i+1, t.To)
+		}
+		for _, f := range t.Require {
+			if f != fieldComment {
+				return fmt.Errorf("transition #%d to %s: unknown required field %q", i+1, t.To, f)
+			}
+		}
+		for _, from := range t.From {
+			st, ok := w.statuses[from]
+			if !ok {
+				return fmt.Errorf("transition #%d: unknown status %q", i+1, from)
+			}
+			if st.Terminal {
+				return fmt.Errorf("transition #%d: terminal status %s cannot have outgoing transitions", i+1, from)
+			}
+			if from == t.To {
+				return fmt.Errorf("transition #%d: %s -> %s leads to the same status", i+1, from, t.To)
+			}
+			if w.transitions[from] == nil {
+				w.transitions[from] = make(map[OrderStatus]*WorkflowTransition)
+			}
+			if _, dup := w.transitions[from][t.To]; dup {
+				return fmt.Errorf("transition %s -> %s is defined twice", from, t.To)
+			}
+			w.transitions[from][t.To] = t
+		}
+	}
+
+	// каждый статус достижим из initial...
+	reached := map[OrderStatus]bool{w.Initial: true}
+	queue := []OrderStatus{w.Initial}
+	for len(queue) > 0 {
+		from := queue[0]
+		queue = queue[1:]
+		for to := range w.transitions[from] {
+			if !reached[to] {
+				reached[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+	// ...и из каждого можно дойти до финального, без тупиков и замкнутых циклов
+	finishes := make(map[OrderStatus]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, s := range w.Statuses {
+			name := OrderStatus(s.Name)
+			if finishes[name] {
+				continue
+			}
+			ok := s.Terminal
+			for to := range w.transitions[name] {
+				ok = ok || finishes[to]
+			}
+			if ok {
+				finishes[name] = true
+				changed = true
+			}
+		}
+	}
+	for _, s := range w.Statuses {
+		if !reached[OrderStatus(s.Name)] {
+			return fmt.Errorf("status %s is unreachable from %s", s.Name, w.Initial)
+		}
+		if !finishes[OrderStatus(s.Name)] {
+			return fmt.Errorf("status %s cannot reach a terminal status", s.Name)
+		}
+	}
+	return nil
+}
+
+func (w *Workflow) status(name string) (OrderStatus, bool) {
+	_, ok := w.statuses[OrderStatus(name)]
+	return OrderStatus(name), ok
+}
+
+func (w *Workflow) statusNames() string {
+	names := make([]string, 0, len(w.Statuses))
+	for _, s := range w.Statuses {
+		names = append(names, s.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// статус, который получают ещё открытые позиции при переходе заказа в s
+func (w *Workflow) itemStatus(s OrderStatus) (OrderItemStatus, bool) {
+	st, ok := w.statuses[s]
+	if !ok || st.Items == "" {
+		return "", false
+	}
+	return st.Items, true
+}
+
+// можно ли перевести заказ from -> to от имени change (права, комментарий)
+func (w *Workflow) checkTransition(from, to OrderStatus, change *StatusChange) error {
+	t := w.transitions[from][to]
+	if t == nil {
+		return &TransitionError{
+			HTTPStatus: http.StatusBadRequest,
+			Code:       "INVALID_TRANSITION",
+			Message:    fmt.Sprintf("Status transition %s -> %s is not allowed", from, to),
+		}
+	}
+	// роли API-ключа — роли его владельца, scopes их не сужают; ограничить
+	// ключ можно только через permissions, поэтому проверяем оба условия
+	if len(t.Roles) > 0 && !containsAny(change.ActorRoles, t.Roles) {
+		return &TransitionError{
+			HTTPStatus: http.StatusForbidden,
+			Code:       "TRANSITION_FORBIDDEN",
+			Message:    fmt.Sprintf("Transition %s -> %s requires one of roles: %s", from, to, strings.Join(t.Roles, ", ")),
+		}
+	}
+	if len(t.Permissions) > 0 && !containsAny(change.ActorPermissions, t.Permissions) {
+		return &TransitionError{
+			HTTPStatus: http.StatusForbidden,
+			Code:       "TRANSITION_FORBIDDEN",
+			Message:    fmt.Sprintf("Transition %s -> %s requires one of permissions: %s", from, to, strings.Join(t.Permissions, ", ")),
+		}
+	}
+	for _, f := range t.Require {
+		if f == fieldComment && change.Comment == "" {
+			return &TransitionError{
+				HTTPStatus: http.StatusBadRequest,
+				Code:       "COMMENT_REQUIRED",
+				Message:    fmt.Sprintf("Transition %s -> %s requires a comment", from, to),
+			}
+		}
+	}
+	return nil
+}
+
+// GET /v1/orders/workflow
+func handleGetWorkflow(c *gin.Context) {
+	success(c, orderWorkflow)
+}
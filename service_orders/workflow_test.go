@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckTransitionRolesAndPermissions(t *testing.T) {
+	data, err := os.ReadFile("workflow.defects.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := parseWorkflow("workflow.defects.yaml", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		from   OrderStatus
+		to     OrderStatus
+		change StatusChange
+		code   string // пусто — переход разрешён
+	}{
+		{"manager accepts", "on_review", "done",
+			StatusChange{ActorRoles: []string{"manager"}, ActorPermissions: []string{"orders:update:any"}}, ""},
+		// API-ключ менеджера со scope только на чтение: роль есть, права нет
+		{"narrow api key", "on_review", "done",
+			StatusChange{ActorRoles: []string{"manager"}, ActorPermissions: []string{"orders:read:any"}}, "TRANSITION_FORBIDDEN"},
+		// право есть, а роли нет: нужны оба условия
+		{"permission without role", "on_review", "done",
+			StatusChange{ActorRoles: []string{"director"}, ActorPermissions: []string{"orders:update:any"}}, "TRANSITION_FORBIDDEN"},
+		{"engineer", "on_review", "done",
+			StatusChange{ActorRoles: []string{"engineer"}, ActorPermissions: []string{"orders:update:own"}}, "TRANSITION_FORBIDDEN"},
+		{"no requirements", "created", "in_progress", StatusChange{}, ""},
+		{"comment required", "on_review", "reopened", StatusChange{}, "COMMENT_REQUIRED"},
+		{"comment given", "on_review", "reopened", StatusChange{Comment: "fails on CI"}, ""},
+		{"not a transition", "created", "done", StatusChange{ActorPermissions: []string{"orders:update:any"}}, "INVALID_TRANSITION"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := w.checkTransition(tt.from, tt.to, &tt.change)
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("got %v, want transition allowed", err)
+				}
+				return
+			}
+			var te *TransitionError
+			if !errors.As(err, &te) || te.Code != tt.code {
+				t.Fatalf("got %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+// переход только с roles, без permissions
+func TestWorkflowTransitionRoles(t *testing.T) {
+	data := strings.Replace(string(defaultWorkflow), "    to: done\n", "    to: done\n    roles: [manager, admin]\n", 1)
+	w, err := parseWorkflow("test", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager := StatusChange{ActorRoles: []string{"engineer", "manager"}}
+	if err := w.checkTransition("in_progress", "done", &manager); err != nil {
+		t.Errorf("manager: got %v, want transition allowed", err)
+	}
+	engineer := StatusChange{ActorRoles: []string{"engineer"}, ActorPermissions: []string{"orders:update:any"}}
+	var te *TransitionError
+	if err := w.checkTransition("in_progress", "done", &engineer); !errors.As(err, &te) || te.Code != "TRANSITION_FORBIDDEN" {
+		t.Errorf("engineer: got %v, want TRANSITION_FORBIDDEN", err)
+	}
+}
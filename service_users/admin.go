@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// все поля необязательные: меняем только то, что прислали
+type AdminUpdateUserRequest struct {
+	Name   *string   `json:"name" binding:"omitempty,min=1"`
+	Roles  *[]string `json:"roles"`
+	Active *bool     `json:"active"`
+}
+
+// пользователь в ответах админского API
+func adminUserView(u *User) gin.H {
+	h := gin.H{
+		"id":            u.ID,
+		"email":         u.Email,
+		"name":          u.Name,
+		"roles":         u.Roles,
+		"active":        u.Active,
+		"emailVerified": u.EmailVerified,
+		"createdAt":     u.CreatedAt,
+		"updatedAt":     u.UpdatedAt,
+	}
+	if u.DeletedAt != nil {
+		h["deletedAt"] = u.DeletedAt
+	}
+	return h
+}
+
+// действующий admin: роль есть, не отключён и не удалён
+func isActiveAdmin(u *User) bool {
+	return u.Active && u.DeletedAt == nil && hasRole(u.Roles, "admin")
+}
+
+var errLastAdmin = errors.New("cannot remove or disable the last active admin")
+
+func failLastAdmin(c *gin.Context) {
+	fail(c, http.StatusConflict, "LAST_ADMIN", "Cannot remove or disable the last active admin")
+}
+
+// нельзя оставить систему без единого действующего admin. Это ранний отказ
+// до любых изменений; окончательно то же проверяется в транзакции самого
+// изменения (keepLastAdminTx) — на случай параллельных запросов.
+func guardLastAdmin(c *gin.Context, u *User) bool {
+	if !isActiveAdmin(u) {
+		return true
+	}
+	count, err := getAdminsCount()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to count admins")
+		return false
+	}
+	if count <= 1 {
+		failLastAdmin(c)
+		return false
+	}
+	return true
+}
+
+// загрузить неудалённого пользователя по :id; nil — ответ с ошибкой уже отправлен
+func loadUserParam(c *gin.Context) *User {
+	user, err := getUserByID(c.Param("id"))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return nil
+	}
+	if user == nil || user.DeletedAt != nil {
+		fail(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+		return nil
+	}
+	return user
+}
+
+// GET /v1/users/:id (admin)
+func handleGetUser(c *gin.Context) {
+	user := loadUserParam(c)
+	if user == nil {
+		return
+	}
+	success(c, adminUserView(user))
+}
+
+// PATCH /v1/users/:id (admin) — имя, роли, активность
+func handleAdminUpdateUser(c *gin.Context) {
+	var req AdminUpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	user := loadUserParam(c)
+	if user == nil {
+		return
+	}
+
+	var roles []string
+	rolesChanged := false
+	if req.Roles != nil {
+		seen := make(map[string]bool)
+		roles = make([]string, 0, len(*req.Roles))
+		for _, r := range *req.Roles {
+			role, ok := normalizeRole(r)
+			if !ok {
+				fail(c, http.StatusBadRequest, "INVALID_ROLE",
+					"Role must be one of: "+roleNames())
+				return
+			}
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+		sort.Strings(roles)
+		rolesChanged = rolesToString(roles) != rolesToString(user.Roles)
+	}
+	deactivating := req.Active != nil && !*req.Active && user.Active
+
+	if (rolesChanged && !hasRole(roles, "admin")) || deactivating {
+		if !guardLastAdmin(c, user) {
+			return
+		}
+	}
+
+	var err error
+	var changes []string
+	if req.Name != nil && *req.Name != user.Name {
+		if user, err = updateUserProfile(user.ID, *req.Name); err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to update user")
+			return
+		}
+		changes = append(changes, "name")
+	}
+	if rolesChanged {
+		changes = append(changes, fmt.Sprintf("roles=%s->%s", rolesToString(user.Roles), rolesToString(roles)))
+		if user, err = updateUserRoles(user.ID, roles); errors.Is(err, errLastAdmin) {
+			failLastAdmin(c)
+			return
+		} else if err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to update user")
+			return
+		}
+	}
+	if req.Active != nil && *req.Active != user.Active {
+		if user, err = setUserActive(user.ID, *req.Active); errors.Is(err, errLastAdmin) {
+			failLastAdmin(c)
+			return
+		} else if err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to update user")
+			return
+		}
+		changes = append(changes, fmt.Sprintf("active=%t", *req.Active))
+	}
+
+	// роли зашиты в токены, а отключённый пользователь не должен работать
+	// со старыми токенами — в обоих случаях отзываем все сессии
+	if rolesChanged || deactivating {
+		reason := "roles_changed"
+		if deactivating {
+			reason = "deactivated"
+		}
+		if err := revokeAllUserSessions(user.ID, reason, getRequestID(c)); err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to revoke sessions")
+			return
+		}
+	}
+
+	if len(changes) > 0 {
+		writeAudit(c, "user_updated", user.ID, c.GetString("userId"), strings.Join(changes, " "))
+	}
+
+	success(c, adminUserView(user))
+}
+
+// DELETE /v1/users/:id (admin) — мягкое удаление
+func handleDeleteUser(c *gin.Context) {
+	user := loadUserParam(c)
+	if user == nil {
+		return
+	}
+	if !guardLastAdmin(c, user) {
+		return
+	}
+
+	if err := softDeleteUser(user.ID); errors.Is(err, errLastAdmin) {
+		failLastAdmin(c)
+		return
+	} else if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to delete user")
+		return
+	}
+	if err := revokeAllUserSessions(user.ID, "deleted", getRequestID(c)); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to revoke sessions")
+		return
+	}
+
+	writeAudit(c, "user_deleted", user.ID, c.GetString("userId"), "email="+user.Email)
+
+	success(c, gin.H{
+		"id":      user.ID,
+		"deleted": true,
+	})
+}
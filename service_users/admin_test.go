@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// два админа одновременно снимают друг друга: кто-то один должен остаться
+func TestConcurrentChangesKeepLastAdmin(t *testing.T) {
+	changes := map[string]func(u *User) error{
+		"deactivate": func(u *User) error { _, err := setUserActive(u.ID, false); return err },
+		"demote":     func(u *User) error { _, err := updateUserRoles(u.ID, []string{"engineer"}); return err },
+		"delete":     func(u *User) error { return softDeleteUser(u.ID) },
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			openTestDB(t)
+			admins := []*User{
+				createTestUser(t, "a1@example.com", "admin"),
+				createTestUser(t, "a2@example.com", "admin"),
+			}
+
+			var wg sync.WaitGroup
+			errs := make([]error, len(admins))
+			for i, u := range admins {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs[i] = change(u)
+				}()
+			}
+			wg.Wait()
+
+			refused := 0
+			for _, err := range errs {
+				switch {
+				case errors.Is(err, errLastAdmin):
+					refused++
+				case err != nil:
+					t.Fatal(err)
+				}
+			}
+			if refused != 1 {
+				t.Errorf("got %d changes refused, want exactly 1", refused)
+			}
+			if n, err := getAdminsCount(); err != nil || n != 1 {
+				t.Errorf("got %d active admins (err %v), want 1", n, err)
+			}
+		})
+	}
+}
+
+// без единого админа (до bootstrap) остальные пользователи меняются как обычно
+func TestChangesWithoutAdmins(t *testing.T) {
+	openTestDB(t)
+	u := createTestUser(t, "bob@example.com", "engineer")
+	if _, err := setUserActive(u.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := softDeleteUser(u.ID); err != nil {
+		t.Fatal(err)
+	}
+}
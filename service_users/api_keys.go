@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ключ вида fw2_<prefix>.<secret>; fw2_<prefix> хранится открыто, чтобы
+// пользователь мог узнать ключ в списке
+const apiKeyPrefix = "fw2_"
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes" binding:"required,min=1"`
+	// срок жизни в днях (по умолчанию API_KEY_DEFAULT_TTL)
+	ExpiresInDays int `json:"expiresInDays" binding:"omitempty,min=1"`
+}
+
+type VerifyAPIKeyRequest struct {
+	Key string `json:"key" binding:"required"`
+}
+
+func generateAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	secret, _, err := generateOpaqueToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	prefix = apiKeyPrefix + hex.EncodeToString(b)
+	key = prefix + "." + secret
+	return key, prefix, hashOpaqueToken(key), nil
+}
+
+// права ключа = его scopes ∩ текущие права пользователя: если роль отобрали,
+// ключ её не сохранит
+func apiKeyPermissions(key *APIKey, permissions []string) []string {
+	allowed := make(map[string]bool, len(key.Scopes))
+	for _, s := range key.Scopes {
+		allowed[s] = true
+	}
+	result := make([]string, 0, len(key.Scopes))
+	for _, p := range permissions {
+		if allowed[p] {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// POST /v1/users/me/api-keys — ключ показывается только в этом ответе
+func handleCreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "name is required")
+		return
+	}
+
+	user := loadCurrentUser(c)
+	if user == nil {
+		return
+	}
+
+	// выдать ключу можно только те права, что есть у пользователя сейчас
+	_, permissions, err := userAccess(user)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to load permissions")
+		return
+	}
+	have := make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		have[p] = true
+	}
+	scopes := make([]string, 0, len(req.Scopes))
+	seen := make(map[string]bool, len(req.Scopes))
+	for _, s := range req.Scopes {
+		s = strings.TrimSpace(s)
+		if !have[s] {
+			fail(c, http.StatusBadRequest, "INVALID_SCOPE", "You don't have permission "+s)
+			return
+		}
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+
+	ttl := apiKeyDefaultTTL
+	if req.ExpiresInDays > 0 {
+		ttl = time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	}
+	if ttl > apiKeyMaxTTL {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "API key lifetime must not exceed "+apiKeyMaxTTL.String())
+		return
+	}
+
+	count, err := countActiveAPIKeys(user.ID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to count API keys")
+		return
+	}
+	if apiKeyMaxPerUser > 0 && count >= apiKeyMaxPerUser {
+		fail(c, http.StatusConflict, "API_KEY_LIMIT", "Too many active API keys, revoke an unused one first")
+		return
+	}
+
+	plain, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "TOKEN_ERROR", "Failed to generate API key")
+		return
+	}
+	key := &APIKey{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := insertAPIKey(key); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to save API key")
+		return
+	}
+
+	writeAudit(c, "api_key_created", user.ID, user.ID, "key="+key.ID+" scopes="+strings.Join(scopes, ","))
+
+	success(c, gin.H{
+		"apiKey": key,
+		"key":    plain,
+	})
+}
+
+// GET /v1/users/me/api-keys
+func handleListAPIKeys(c *gin.Context) {
+	keys, err := listUserAPIKeys(c.GetString("userId"))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to list API keys")
+		return
+	}
+
+	success(c, gin.H{
+		"items": keys,
+	})
+}
+
+// DELETE /v1/users/me/api-keys/:keyId
+func handleRevokeAPIKey(c *gin.Context) {
+	userID := c.GetString("userId")
+	keyID := c.Param("keyId")
+
+	ok, err := revokeAPIKey(keyID, userID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to revoke API key")
+		return
+	}
+	if !ok {
+		fail(c, http.StatusNotFound, "API_KEY_NOT_FOUND", "API key not found or already revoked")
+		return
+	}
+
+	writeAudit(c, "api_key_revoked", userID, userID, "key="+keyID)
+	// шлюз сбрасывает кэш проверенных ключей
+	notifyRevocation()
+
+	success(c, gin.H{
+		"id":      keyID,
+		"revoked": true,
+	})
+}
+
+// POST /internal/api-keys/verify — шлюз меняет ключ на личность пользователя
+func handleVerifyAPIKey(c *gin.Context) {
+	var req VerifyAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	key, err := getAPIKeyByHash(hashOpaqueToken(strings.TrimSpace(req.Key)))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query API key")
+		return
+	}
+	if key == nil || key.RevokedAt != nil || time.Now().After(key.ExpiresAt) {
+		fail(c, http.StatusUnauthorized, "INVALID_API_KEY", "API key is invalid, expired or revoked")
+		return
+	}
+
+	user, err := getUserByID(key.UserID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return
+	}
+	if user == nil || user.DeletedAt != nil || !user.Active || emailVerificationBlocksLogin(user) {
+		fail(c, http.StatusUnauthorized, "INVALID_API_KEY", "API key is invalid, expired or revoked")
+		return
+	}
+
+	roles, permissions, err := userAccess(user)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to load permissions")
+		return
+	}
+	if err := touchAPIKey(key.ID); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to update API key")
+		return
+	}
+
+	success(c, gin.H{
+		"keyId":       key.ID,
+		"userId":      user.ID,
+		"roles":       roles,
+		"permissions": apiKeyPermissions(key, permissions),
+		"expiresAt":   key.ExpiresAt,
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var apiKeyFormat = regexp.MustCompile(`^fw2_[0-9a-f]{8}\.[A-Za-z0-9_-]{43}$`)
+
+func TestGenerateAPIKeyFormat(t *testing.T) {
+	key, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !apiKeyFormat.MatchString(key) {
+		t.Fatalf("key %q does not match fw2_<prefix>.<secret>", key)
+	}
+	if got, _, _ := strings.Cut(key, "."); got != prefix {
+		t.Errorf("prefix %q, want %q from the key", prefix, got)
+	}
+	// хеш — от ключа целиком, а не от секрета
+	if hash != hashOpaqueToken(key) {
+		t.Error("hash is not sha256 of the full key")
+	}
+
+	other, _, _, err := generateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key {
+		t.Error("two generated keys are equal")
+	}
+}
+
+func TestAPIKeyPermissionsIntersection(t *testing.T) {
+	tests := []struct {
+		name        string
+		scopes      []string
+		permissions []string
+		want        []string
+	}{
+		{"subset", []string{permOrdersReadOwn}, []string{permOrdersCreate, permOrdersReadOwn}, []string{permOrdersReadOwn}},
+		// права, которых у пользователя нет (или уже нет), ключ не даёт
+		{"scope above user", []string{permOrdersReadOwn, permUsersManage}, []string{permOrdersReadOwn}, []string{permOrdersReadOwn}},
+		{"role taken away", []string{permOrdersReadAny}, []string{permOrdersReadOwn}, []string{}},
+		{"no permissions", []string{permOrdersReadOwn}, nil, []string{}},
+	}
+	for _, tt := range tests {
+		got := apiKeyPermissions(&APIKey{Scopes: tt.scopes}, tt.permissions)
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func insertTestAPIKey(t *testing.T, u *User, ttl time.Duration, scopes ...string) (*APIKey, string) {
+	t.Helper()
+	plain, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &APIKey{ID: uuid.NewString(), UserID: u.ID, Name: "ci", Prefix: prefix, KeyHash: hash, Scopes: scopes, ExpiresAt: time.Now().Add(ttl)}
+	if err := insertAPIKey(k); err != nil {
+		t.Fatal(err)
+	}
+	return k, plain
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	openTestDB(t)
+	u := createTestUser(t, "bob@example.com", "engineer")
+	// ключ выпущен, когда у пользователя было больше прав
+	key, plain := insertTestAPIKey(t, u, time.Hour, permOrdersReadOwn, permOrdersReadAny, permUsersManage)
+	_, expired := insertTestAPIKey(t, u, -time.Minute, permOrdersReadOwn)
+
+	notified := make(chan struct{}, 1)
+	gatewayStub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case notified <- struct{}{}:
+		default:
+		}
+	}))
+	defer gatewayStub.Close()
+	prevURLs := revocationNotifyURLs
+	revocationNotifyURLs = []string{gatewayStub.URL}
+	t.Cleanup(func() { revocationNotifyURLs = prevURLs })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/internal/api-keys/verify", handleVerifyAPIKey)
+	router.DELETE("/v1/users/me/api-keys/:keyId", func(c *gin.Context) { c.Set("userId", u.ID) }, handleRevokeAPIKey)
+	verify := func(k string) (int, string, map[string]any) {
+		return doJSON(t, router, http.MethodPost, "/internal/api-keys/verify", VerifyAPIKeyRequest{Key: k}, nil)
+	}
+
+	status, code, data := verify(plain)
+	if status != http.StatusOK {
+		t.Fatalf("verify: got %d %s, want 200", status, code)
+	}
+	if data["userId"] != u.ID || data["keyId"] != key.ID {
+		t.Errorf("verify: got user %v key %v", data["userId"], data["keyId"])
+	}
+	var perms []string
+	for _, p := range data["permissions"].([]any) {
+		perms = append(perms, p.(string))
+	}
+	if !slices.Equal(perms, []string{permOrdersReadOwn}) {
+		t.Errorf("verify: got permissions %v, want only %s", perms, permOrdersReadOwn)
+	}
+
+	if status, code, _ := verify(expired); status != http.StatusUnauthorized || code != "INVALID_API_KEY" {
+		t.Errorf("expired key: got %d %s, want 401 INVALID_API_KEY", status, code)
+	}
+	if status, code, _ := verify(plain + "x"); status != http.StatusUnauthorized || code != "INVALID_API_KEY" {
+		t.Errorf("unknown key: got %d %s, want 401 INVALID_API_KEY", status, code)
+	}
+
+	// отзыв: ключ перестаёт проверяться, шлюз получает уведомление
+	if status, code, _ := doJSON(t, router, http.MethodDelete, "/v1/users/me/api-keys/"+key.ID, nil, nil); status != http.StatusOK {
+		t.Fatalf("revoke: got %d %s, want 200", status, code)
+	}
+	if status, code, _ := verify(plain); status != http.StatusUnauthorized || code != "INVALID_API_KEY" {
+		t.Errorf("revoked key: got %d %s, want 401 INVALID_API_KEY", status, code)
+	}
+	select {
+	case <-notified:
+	case <-time.After(5 * time.Second):
+		t.Error("gateway was not notified about the revoked key")
+	}
+	if status, code, _ := doJSON(t, router, http.MethodDelete, "/v1/users/me/api-keys/"+key.ID, nil, nil); status != http.StatusNotFound {
+		t.Errorf("second revoke: got %d %s, want 404", status, code)
+	}
+}
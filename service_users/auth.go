@@ -1,23 +1,33 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// jti (RegisteredClaims.ID) — id токена для точечного отзыва,
+// sid — цепочка refresh-токенов (логин), к которой относится токен
 type UserClaims struct {
 	UserID string   `json:"userId"`
 	Roles  []string `json:"roles"`
+	// права ролей на момент выпуска токена, например orders:update:any
+	Permissions   []string `json:"permissions"`
+	EmailVerified bool     `json:"emailVerified"`
+	SessionID     string   `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-var jwtSecret = []byte(jwtSecretString)
-
 func hashPassword(plain string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(plain), bcrypt.DefaultCost)
 	if err != nil {
@@ -31,20 +41,77 @@ func checkPassword(hash, plain string) bool {
 	return err == nil
 }
 
-func generateToken(user *User) (string, error) {
+// роли и права, которые попадают в токен: роли, требующие 2FA, выдаются
+// только при включённой 2FA, а без подтверждённого email (EMAIL_VERIFICATION=orders)
+// нет права создавать заказы
+func userAccess(user *User) (roles, permissions []string, err error) {
+	roles = user.Roles
+	if !user.MFAEnabled {
+		required, err := getMFARequiredRoles()
+		if err != nil {
+			return nil, nil, err
+		}
+		roles = make([]string, 0, len(user.Roles))
+		for _, r := range user.Roles {
+			if !required[r] {
+				roles = append(roles, r)
+			}
+		}
+	}
+
+	permissions, err = getPermissionsForRoles(roles)
+	if err != nil || user.EmailVerified || emailVerificationMode != "orders" {
+		return roles, permissions, err
+	}
+	filtered := make([]string, 0, len(permissions))
+	for _, p := range permissions {
+		if p != permOrdersCreate {
+			filtered = append(filtered, p)
+		}
+	}
+	return roles, filtered, nil
+}
+
+func generateToken(user *User, sessionID string) (string, *UserClaims, error) {
+	roles, permissions, err := userAccess(user)
+	if err != nil {
+		return "", nil, err
+	}
+
 	now := time.Now()
 	claims := UserClaims{
-		UserID: user.ID,
-		Roles:  user.Roles,
+		UserID:        user.ID,
+		Roles:         roles,
+		Permissions:   permissions,
+		EmailVerified: user.EmailVerified,
+		SessionID:     sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(now.Add(tokenTTL)),
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	token := jwt.NewWithClaims(activeKey.method, claims)
+	token.Header["kid"] = activeKey.kid
+	signed, err := token.SignedString(activeKey.private)
+	return signed, &claims, err
+}
+
+// непрозрачный refresh-токен: клиенту отдаём сам токен, в БД храним только sha256
+func generateOpaqueToken() (plain, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	plain = base64.RawURLEncoding.EncodeToString(b)
+	return plain, hashOpaqueToken(plain), nil
+}
+
+func hashOpaqueToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
 }
 
 func parseToken(tokenString string) (*UserClaims, error) {
@@ -52,11 +119,14 @@ func parseToken(tokenString string) (*UserClaims, error) {
 		tokenString,
 		&UserClaims{},
 		func(token *jwt.Token) (interface{}, error) {
-			if token.Method != jwt.SigningMethodHS256 {
-				return nil, jwt.ErrTokenMalformed
+			kid, _ := token.Header["kid"].(string)
+			k, ok := signingKeys[kid]
+			if !ok || token.Method.Alg() != k.method.Alg() {
+				return nil, jwt.ErrTokenUnverifiable
 			}
-			return jwtSecret, nil
+			return k.private.Public(), nil
 		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}),
 	)
 	if err != nil {
 		return nil, err
@@ -70,8 +140,25 @@ func parseToken(tokenString string) (*UserClaims, error) {
 	return claims, nil
 }
 
+// принимает либо подписанные шлюзом X-User-ID / X-User-Roles, либо Bearer-токен
 func AuthRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if hasIdentityHeaders(c) {
+			userID, roles, permissions, err := verifyIdentityHeaders(c)
+			if err != nil {
+				fail(c, http.StatusUnauthorized, "INVALID_IDENTITY", "Identity headers are invalid or expired")
+				c.Abort()
+				return
+			}
+
+			c.Set("userId", userID)
+			c.Set("roles", roles)
+			c.Set("permissions", permissions)
+
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			fail(c, http.StatusUnauthorized, "AUTH_REQUIRED", "Missing Authorization header")
@@ -95,37 +182,67 @@ func AuthRequired() gin.HandlerFunc {
 			return
 		}
 
+		revoked, err := isTokenRevoked(claims)
+		if err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to check token revocation")
+			c.Abort()
+			return
+		}
+		if revoked {
+			fail(c, http.StatusUnauthorized, "TOKEN_REVOKED", "Token has been revoked")
+			c.Abort()
+			return
+		}
+
 		c.Set("userId", claims.UserID)
 		c.Set("roles", claims.Roles)
+		c.Set("permissions", claims.Permissions)
+
+		c.Next()
+	}
+}
+
+// Bearer-токен из заголовка Authorization (пустая строка, если его нет)
+func bearerToken(c *gin.Context) string {
+	parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return ""
+	}
+	return parts[1]
+}
 
+// внутренние эндпоинты для api_gateway / service_orders: X-Internal-Token = INTERNAL_AUTH_SECRET
+func InternalRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader("X-Internal-Token")
+		if len(internalAuthSecret) == 0 || !hmac.Equal([]byte(token), internalAuthSecret) {
+			fail(c, http.StatusForbidden, "FORBIDDEN", "Internal token required")
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
 
-// проверка, что у пользователя есть роль admin
-func AdminRequired() gin.HandlerFunc {
+// проверка, что у пользователя есть право (например users:manage)
+func PermissionRequired(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		rolesVal, ok := c.Get("roles")
+		permsVal, ok := c.Get("permissions")
 		if !ok {
-			fail(c, http.StatusForbidden, "FORBIDDEN", "Roles missing in context")
+			fail(c, http.StatusForbidden, "FORBIDDEN", "Permissions missing in context")
 			c.Abort()
 			return
 		}
-		roles, _ := rolesVal.([]string)
+		permissions, _ := permsVal.([]string)
 
-		isAdmin := false
-		for _, r := range roles {
-			if r == "admin" {
-				isAdmin = true
-				break
+		for _, p := range permissions {
+			if p == permission {
+				c.Next()
+				return
 			}
 		}
-		if !isAdmin {
-			fail(c, http.StatusForbidden, "FORBIDDEN", "Admin role required")
-			c.Abort()
-			return
-		}
 
-		c.Next()
+		fail(c, http.StatusForbidden, "FORBIDDEN", "Permission "+permission+" required")
+		c.Abort()
 	}
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var errAdminExists = errors.New("admin already exists")
+
+// создать первого админа. Срабатывает один раз: если админ уже есть,
+// ничего не делаем. Существующего пользователя с тем же email не повышаем —
+// адрес мог заранее занять кто угодно через открытую регистрацию.
+func bootstrapAdmin(email, name, password string) (*User, error) {
+	count, err := getAdminsCount()
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errAdminExists
+	}
+
+	existing, err := getUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("user %s already exists and will not be promoted", email)
+	}
+
+	// та же политика, что при регистрации и смене пароля
+	if violations := passwordViolations(password); len(violations) > 0 {
+		msgs := make([]string, len(violations))
+		for i, v := range violations {
+			msgs[i] = v.Message
+		}
+		return nil, fmt.Errorf("BOOTSTRAP_ADMIN_PASSWORD does not meet the password policy: %s", strings.Join(msgs, "; "))
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = "Administrator"
+	}
+	user := &User{
+		ID:           uuid.NewString(),
+		Email:        email,
+		Name:         name,
+		PasswordHash: hash,
+		Roles:        []string{"admin"},
+		// адрес задал оператор — подтверждать его письмом некому
+		EmailVerified: true,
+	}
+	if err := insertUser(user); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Bootstrap admin created: id=%s email=%s", user.ID, user.Email)
+	return user, nil
+}
+
+// BOOTSTRAP_ADMIN_EMAIL / BOOTSTRAP_ADMIN_PASSWORD при старте сервиса
+func bootstrapAdminFromEnv() error {
+	email := getenv("BOOTSTRAP_ADMIN_EMAIL", "")
+	if email == "" {
+		return nil
+	}
+	password := getenv("BOOTSTRAP_ADMIN_PASSWORD", "")
+	if password == "" {
+		return errors.New("BOOTSTRAP_ADMIN_PASSWORD is required with BOOTSTRAP_ADMIN_EMAIL")
+	}
+
+	_, err := bootstrapAdmin(email, getenv("BOOTSTRAP_ADMIN_NAME", ""), password)
+	if errors.Is(err, errAdminExists) {
+		return nil
+	}
+	return err
+}
+
+// ./service_users bootstrap-admin -email a@b.c [-name N]
+// пароль берётся из BOOTSTRAP_ADMIN_PASSWORD, чтобы не светить его в списке процессов
+func runBootstrapAdminCommand(args []string) {
+	fs := flag.NewFlagSet("bootstrap-admin", flag.ExitOnError)
+	email := fs.String("email", getenv("BOOTSTRAP_ADMIN_EMAIL", ""), "admin email")
+	name := fs.String("name", getenv("BOOTSTRAP_ADMIN_NAME", ""), "admin name")
+	_ = fs.Parse(args)
+
+	password := getenv("BOOTSTRAP_ADMIN_PASSWORD", "")
+	if *email == "" || password == "" {
+		fmt.Fprintln(os.Stderr, "usage: BOOTSTRAP_ADMIN_PASSWORD=... service_users bootstrap-admin -email admin@example.com [-name Admin]")
+		os.Exit(2)
+	}
+
+	if _, err := bootstrapAdmin(*email, *name, password); err != nil {
+		log.Fatalf("bootstrap admin: %v", err)
+	}
+}
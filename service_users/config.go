@@ -3,6 +3,8 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -14,17 +16,161 @@ const (
 )
 
 var (
-	jwtSecretString string
-	tokenTTL        = 24 * time.Hour
+	jwtKeysDir         string
+	jwtSigningKeyID    string
+	internalAuthSecret []byte
+	// куда слать push-уведомления об отзыве токенов (через запятую)
+	revocationNotifyURLs []string
+	accessTokenTTL       = 15 * time.Minute
+	refreshTokenTTL      = 30 * 24 * time.Hour
+
+	// откуда принимаем X-Forwarded-For (api_gateway): IP клиента нужен
+	// для счётчиков неудачных входов и журнала входов
+	trustedProxies []string
+
+	// защита от подбора пароля
+	loginMaxFailures   = 5                // неудач подряд на аккаунт до блокировки
+	loginIPMaxFailures = 30               // неудач с одного IP до блокировки IP
+	loginFailureWindow = 15 * time.Minute // после такой паузы счётчик начинается заново
+	loginLockout       = 15 * time.Minute
+	loginDelayBase     = time.Second // задержка после первой неудачи, дальше x2
+	loginDelayMax      = 30 * time.Second
+
+	// роли, которые можно выбрать при открытой регистрации; остальные —
+	// только по приглашению или через заявку, одобренную админом
+	selfServiceRoles = []string{"customer", "engineer"}
+	// false — регистрация с повышенной ролью без приглашения отклоняется
+	roleApprovalQueue = true
+	inviteTTL         = 72 * time.Hour
+
+	// почта: smtp / file (письма .eml в mailDir, по умолчанию) / memory (в памяти
+	// процесса, последние memoryMailerLimit писем — только для тестов)
+	mailDriver   string
+	mailFrom     string
+	mailDir      string
+	smtpAddr     string
+	smtpUsername string
+	smtpPassword string
+
+	// подтверждение email: off — не требуется, login — без него нельзя войти,
+	// orders — можно войти, но в токене нет права orders:create
+	emailVerificationMode = "off"
+	emailVerificationTTL  = 48 * time.Hour
+	// ссылка в письме: к ней дописывается токен (пусто — в письме только токен)
+	emailVerificationURL string
+
+	// 2FA: имя сервиса в приложении-аутентификаторе и срок жизни второго шага логина
+	mfaIssuer       = "Framework2"
+	mfaChallengeTTL = 5 * time.Minute
+
+	// политика паролей: для регистрации, смены и сброса
+	passwordMinLength       = 8
+	passwordRequiredClasses = []string{"lower", "upper", "digit"}
+	passwordDenylistPath    string
+	passwordHistorySize     = 5 // нельзя повторять текущий и столько-1 предыдущих; 0 — не проверять
+
+	// персональные API-ключи: срок по умолчанию, максимальный срок и сколько
+	// действующих ключей может быть у пользователя (0 — без ограничения)
+	apiKeyDefaultTTL = 90 * 24 * time.Hour
+	apiKeyMaxTTL     = 365 * 24 * time.Hour
+	apiKeyMaxPerUser = 10
+
+	passwordResetTTL = time.Hour
+	// ссылка в письме: к ней дописывается токен (пусто — в письме только токен)
+	passwordResetURL string
 )
 
 // загружаем .env и инициализируем глобальные конфиги
 func initConfig() {
 	_ = godotenv.Load()
 
-	jwtSecretString = getenv("JWT_SECRET", "dev-secret-change-me")
+	// приватные ключи подписи JWT (*.pem, kid = имя файла) и активный kid
+	jwtKeysDir = getenv("JWT_KEYS_DIR", "keys")
+	jwtSigningKeyID = getenv("JWT_SIGNING_KEY_ID", "")
+	// ключ проверки подписанных шлюзом заголовков личности (пусто — только Bearer)
+	internalAuthSecret = []byte(getenv("INTERNAL_AUTH_SECRET", ""))
+
+	for _, u := range strings.Split(getenv("REVOCATION_NOTIFY_URLS", ""), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			revocationNotifyURLs = append(revocationNotifyURLs, u)
+		}
+	}
+
+	for _, p := range strings.Split(getenv("TRUSTED_PROXIES", "127.0.0.1,::1"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			trustedProxies = append(trustedProxies, p)
+		}
+	}
+
+	accessTokenTTL = getenvDuration("ACCESS_TOKEN_TTL", accessTokenTTL)
+	refreshTokenTTL = getenvDuration("REFRESH_TOKEN_TTL", refreshTokenTTL)
+
+	loginMaxFailures = getenvInt("LOGIN_MAX_FAILURES", loginMaxFailures)
+	loginIPMaxFailures = getenvInt("LOGIN_IP_MAX_FAILURES", loginIPMaxFailures)
+	loginFailureWindow = getenvDuration("LOGIN_FAILURE_WINDOW", loginFailureWindow)
+	loginLockout = getenvDuration("LOGIN_LOCKOUT_DURATION", loginLockout)
+	loginDelayBase = getenvDuration("LOGIN_DELAY_BASE", loginDelayBase)
+	loginDelayMax = getenvDuration("LOGIN_DELAY_MAX", loginDelayMax)
+
+	if v := getenv("SELF_SERVICE_ROLES", ""); v != "" {
+		selfServiceRoles = nil
+		for _, r := range strings.Split(v, ",") {
+			role, ok := normalizeRole(strings.TrimSpace(r))
+			if !ok {
+				log.Fatalf("invalid SELF_SERVICE_ROLES: unknown role %q", r)
+			}
+			if role == "admin" {
+				log.Fatalf("invalid SELF_SERVICE_ROLES: admin cannot be self-service")
+			}
+			selfServiceRoles = append(selfServiceRoles, role)
+		}
+	}
+	roleApprovalQueue = getenv("ROLE_APPROVAL_QUEUE", "true") == "true"
+	inviteTTL = getenvDuration("INVITE_TTL", inviteTTL)
+
+	mailDriver = getenv("MAIL_DRIVER", "file")
+	mailFrom = getenv("MAIL_FROM", "no-reply@localhost")
+	mailDir = getenv("MAIL_DIR", "mail")
+	smtpAddr = getenv("SMTP_ADDR", "")
+	smtpUsername = getenv("SMTP_USERNAME", "")
+	smtpPassword = getenv("SMTP_PASSWORD", "")
+
+	emailVerificationMode = getenv("EMAIL_VERIFICATION", emailVerificationMode)
+	switch emailVerificationMode {
+	case "off", "login", "orders":
+	default:
+		log.Fatalf("invalid EMAIL_VERIFICATION %q (off, login, orders)", emailVerificationMode)
+	}
+	emailVerificationTTL = getenvDuration("EMAIL_VERIFICATION_TTL", emailVerificationTTL)
+	emailVerificationURL = getenv("EMAIL_VERIFICATION_URL", "")
+
+	mfaIssuer = getenv("MFA_ISSUER", mfaIssuer)
+	mfaChallengeTTL = getenvDuration("MFA_CHALLENGE_TTL", mfaChallengeTTL)
+
+	passwordMinLength = getenvInt("PASSWORD_MIN_LENGTH", passwordMinLength)
+	// "none" — не требовать ни одного класса символов
+	if v := getenv("PASSWORD_REQUIRED_CLASSES", ""); v != "" {
+		passwordRequiredClasses = nil
+		for _, class := range strings.Split(v, ",") {
+			if class = strings.ToLower(strings.TrimSpace(class)); class != "" && class != "none" {
+				passwordRequiredClasses = append(passwordRequiredClasses, class)
+			}
+		}
+	}
+	passwordDenylistPath = getenv("PASSWORD_DENYLIST_FILE", "")
+	passwordHistorySize = getenvInt("PASSWORD_HISTORY", passwordHistorySize)
+
+	apiKeyDefaultTTL = getenvDuration("API_KEY_DEFAULT_TTL", apiKeyDefaultTTL)
+	apiKeyMaxTTL = getenvDuration("API_KEY_MAX_TTL", apiKeyMaxTTL)
+	apiKeyMaxPerUser = getenvInt("API_KEY_MAX_PER_USER", apiKeyMaxPerUser)
+	if apiKeyDefaultTTL > apiKeyMaxTTL {
+		log.Fatalf("invalid API_KEY_DEFAULT_TTL: must not exceed API_KEY_MAX_TTL (%s)", apiKeyMaxTTL)
+	}
+
+	passwordResetTTL = getenvDuration("PASSWORD_RESET_TTL", passwordResetTTL)
+	passwordResetURL = getenv("PASSWORD_RESET_URL", "")
 
-	log.Println("Config initialized, JWT_SECRET length:", len(jwtSecretString))
+	log.Println("Config initialized, JWT keys dir:", jwtKeysDir)
 }
 
 func getenv(key, def string) string {
@@ -33,3 +179,27 @@ func getenv(key, def string) string {
 	}
 	return def
 }
+
+func getenvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return d
+}
+
+func getenvInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return n
+}
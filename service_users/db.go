@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -28,16 +29,267 @@ func initDB() error {
 		email TEXT NOT NULL UNIQUE,
 		name TEXT NOT NULL,
 		password_hash TEXT NOT NULL,
-		roles TEXT NOT NULL,
 		created_at DATETIME NOT NULL,
 		updated_at DATETIME NOT NULL
 	);
+
+	CREATE TABLE IF NOT EXISTS roles (
+		name TEXT PRIMARY KEY,
+		description TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS role_permissions (
+		role TEXT NOT NULL REFERENCES roles(name),
+		permission TEXT NOT NULL,
+		PRIMARY KEY (role, permission)
+	);
+
+	CREATE TABLE IF NOT EXISTS user_roles (
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL REFERENCES roles(name),
+		PRIMARY KEY (user_id, role)
+	);
+	CREATE INDEX IF NOT EXISTS idx_user_roles_role ON user_roles(role);
+
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id TEXT PRIMARY KEY,
+		family_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME,
+		revoked_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family_id);
+
+	CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS user_revocations (
+		user_id TEXT PRIMARY KEY,
+		revoked_before DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+
+	-- неудачные попытки входа: scope = account (ключ — email) или ip
+	CREATE TABLE IF NOT EXISTS login_failures (
+		scope TEXT NOT NULL,
+		key TEXT NOT NULL,
+		failures INTEGER NOT NULL,
+		last_failure_at DATETIME NOT NULL,
+		locked_until DATETIME,
+		PRIMARY KEY (scope, key)
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id TEXT PRIMARY KEY,
+		event TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		actor_id TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		request_id TEXT NOT NULL,
+		details TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_user ON audit_log(user_id, created_at);
+
+	-- приглашения на повышенные роли (код хранится только как sha256)
+	CREATE TABLE IF NOT EXISTS invites (
+		id TEXT PRIMARY KEY,
+		code_hash TEXT NOT NULL UNIQUE,
+		role TEXT NOT NULL,
+		email TEXT NOT NULL,
+		created_by TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME,
+		used_by TEXT
+	);
+
+	-- заявки на повышенную роль, поданные при регистрации без приглашения
+	CREATE TABLE IF NOT EXISTS role_requests (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		status TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		decided_at DATETIME,
+		decided_by TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_role_requests_status ON role_requests(status, created_at);
+
+	-- токены сброса пароля (хранится только sha256)
+	CREATE TABLE IF NOT EXISTS password_resets (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_password_resets_user ON password_resets(user_id);
+
+	-- прежние хеши паролей, чтобы не давать вернуться к недавним
+	CREATE TABLE IF NOT EXISTS password_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		password_hash TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_password_history_user ON password_history(user_id, id);
+
+	-- токены подтверждения email (хранится только sha256)
+	CREATE TABLE IF NOT EXISTS email_verifications (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_email_verifications_user ON email_verifications(user_id);
+
+	-- TOTP-секрет (enabled = 0 — ещё не подтверждён кодом); last_used_step
+	-- не даёт использовать один и тот же код дважды
+	CREATE TABLE IF NOT EXISTS user_mfa (
+		user_id TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		enabled INTEGER NOT NULL,
+		last_used_step INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		enabled_at DATETIME
+	);
+
+	-- одноразовые коды восстановления 2FA (хранится только sha256)
+	CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+		code_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		used_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user ON mfa_recovery_codes(user_id);
+
+	-- второй шаг логина при включённой 2FA (хранится только sha256 токена)
+	CREATE TABLE IF NOT EXISTS mfa_challenges (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		attempts INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME
+	);
+
+	-- персональные API-ключи: хранится sha256 ключа, prefix показываем в списке
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		last_used_at DATETIME,
+		revoked_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id);
+
+	-- сессия = цепочка refresh-токенов одного логина (id = family_id)
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		user_agent TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		last_seen_at DATETIME NOT NULL,
+		last_ip TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+
+	-- журнал попыток входа; reason — код ошибки из ответа (пусто при успехе)
+	CREATE TABLE IF NOT EXISTS login_events (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		success INTEGER NOT NULL,
+		method TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		user_agent TEXT NOT NULL,
+		request_id TEXT NOT NULL,
+		session_id TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_login_events_user ON login_events(user_id, created_at);
 	`
 	if _, err := d.Exec(schema); err != nil {
 		return err
 	}
 
+	if err := seedRoles(d); err != nil {
+		return err
+	}
+	if err := migrateRolesColumn(d); err != nil {
+		return err
+	}
+
+	// колонки, добавленные после первой версии схемы
+	migrations := []struct{ table, column, def string }{
+		{"users", "active", "INTEGER NOT NULL DEFAULT 1"},
+		{"users", "deleted_at", "DATETIME"},
+		// пользователи, созданные до появления проверки, считаются подтверждёнными
+		{"users", "email_verified", "INTEGER NOT NULL DEFAULT 1"},
+		// роль выдаётся в токене только пользователям с включённой 2FA
+		{"roles", "mfa_required", "INTEGER NOT NULL DEFAULT 0"},
+		// access-токен, выданный вместе с refresh: нужен, чтобы завершить сессию сразу
+		{"refresh_tokens", "access_jti", "TEXT"},
+		{"refresh_tokens", "access_expires_at", "DATETIME"},
+	}
+	for _, m := range migrations {
+		if err := addColumnIfMissing(d, m.table, m.column, m.def); err != nil {
+			return err
+		}
+	}
+
 	db = d
 	log.Println("SQLite initialized at", dbPath)
 	return nil
 }
+
+func hasColumn(d *sql.DB, table, column string) (bool, error) {
+	rows, err := d.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+func addColumnIfMissing(d *sql.DB, table, column, def string) error {
+	ok, err := hasColumn(d, table, column)
+	if err != nil || ok {
+		return err
+	}
+
+	_, err = d.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, def))
+	if err == nil {
+		log.Printf("DB migration: added %s.%s", table, column)
+	}
+	return err
+}
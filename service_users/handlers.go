@@ -1,8 +1,11 @@
 package main
 
 import (
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -10,9 +13,11 @@ import (
 
 type RegisterRequest struct {
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
 	Name     string `json:"name" binding:"required"`
 	Role     string `json:"role" binding:"required"` // engineer / manager / director / customer / admin
+	// код приглашения для роли вне selfServiceRoles
+	InviteCode string `json:"inviteCode"`
 }
 
 type LoginRequest struct {
@@ -20,17 +25,41 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
 type UpdateProfileRequest struct {
 	Name string `json:"name" binding:"required"`
 }
 
-func normalizeRole(role string) (string, bool) {
-	switch role {
-	case "engineer", "manager", "director", "customer", "admin":
-		return role, true
-	default:
-		return "", false
+func isSelfServiceRole(role string) bool {
+	return hasRole(selfServiceRoles, role)
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// page/limit из query: limit по умолчанию 10, не больше 100
+func pagination(c *gin.Context) (page, limit, offset int) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err = strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 {
+		limit = 10
+	}
+	if limit > 100 {
+		limit = 100
 	}
+	return page, limit, (page - 1) * limit
 }
 
 // POST /v1/users/register
@@ -44,7 +73,7 @@ func handleRegister(c *gin.Context) {
 	baseRole, ok := normalizeRole(req.Role)
 	if !ok {
 		fail(c, http.StatusBadRequest, "INVALID_ROLE",
-			"Role must be one of: engineer, manager, director, customer, admin")
+			"Role must be one of: "+roleNames())
 		return
 	}
 
@@ -58,23 +87,37 @@ func handleRegister(c *gin.Context) {
 		return
 	}
 
-	passwordHash, err := hashPassword(req.Password)
-	if err != nil {
-		fail(c, http.StatusInternalServerError, "HASH_ERROR", "Failed to hash password")
+	// повышенная роль: по приглашению сразу, без него — через заявку
+	var invite *Invite
+	pendingRole := ""
+	if req.InviteCode != "" {
+		invite, ok = checkInvite(c, req.InviteCode, req.Email, baseRole)
+		if !ok {
+			return
+		}
+	} else if !isSelfServiceRole(baseRole) {
+		if !roleApprovalQueue {
+			fail(c, http.StatusForbidden, "ROLE_NOT_SELF_SERVICE",
+				"Role "+baseRole+" requires an invite code; self-service roles: "+strings.Join(selfServiceRoles, ", "))
+			return
+		}
+		pendingRole = baseRole
+	}
+
+	if !checkPasswordPolicy(c, req.Password, nil) {
 		return
 	}
 
-	// считаем админов
-	adminCount, err := getAdminsCount()
+	passwordHash, err := hashPassword(req.Password)
 	if err != nil {
-		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to count admins")
+		fail(c, http.StatusInternalServerError, "HASH_ERROR", "Failed to hash password")
 		return
 	}
 
+	// пока заявка не одобрена, у пользователя нет ролей
 	roles := []string{baseRole}
-	// если админов ещё нет и пользователь регистрируется НЕ как admin — добавляем admin
-	if adminCount == 0 && baseRole != "admin" {
-		roles = append(roles, "admin")
+	if pendingRole != "" {
+		roles = []string{}
 	}
 
 	user := &User{
@@ -85,18 +128,51 @@ func handleRegister(c *gin.Context) {
 		Roles:        roles,
 	}
 
+	if invite != nil {
+		used, err := markInviteUsed(invite.ID, user.ID)
+		if err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to use invite")
+			return
+		}
+		if !used {
+			fail(c, http.StatusBadRequest, "INVALID_INVITE", "Invite code is invalid, expired or already used")
+			return
+		}
+	}
+
 	if err := insertUser(user); err != nil {
+		if invite != nil {
+			_ = releaseInvite(invite.ID)
+		}
 		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to save user")
 		return
 	}
 
+	if pendingRole != "" {
+		if err := insertRoleRequest(&RoleRequest{ID: uuid.NewString(), UserID: user.ID, Role: pendingRole}); err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to save role request")
+			return
+		}
+	}
+	if invite != nil {
+		writeAudit(c, "invite_used", user.ID, invite.CreatedBy, "invite="+invite.ID+" role="+invite.Role)
+	}
+	// пользователь уже создан: письмо можно запросить повторно через verify/resend
+	if err := sendEmailVerification(c, user); err != nil {
+		log.Printf("requestId=%s failed to issue email verification userId=%s: %v", getRequestID(c), user.ID, err)
+	}
+
 	resp := gin.H{
-		"id":        user.ID,
-		"email":     user.Email,
-		"name":      user.Name,
-		"roles":     user.Roles,
-		"createdAt": user.CreatedAt,
-		"updatedAt": user.UpdatedAt,
+		"id":            user.ID,
+		"email":         user.Email,
+		"name":          user.Name,
+		"roles":         user.Roles,
+		"emailVerified": user.EmailVerified,
+		"createdAt":     user.CreatedAt,
+		"updatedAt":     user.UpdatedAt,
+	}
+	if pendingRole != "" {
+		resp["pendingRole"] = pendingRole
 	}
 	success(c, resp)
 }
@@ -114,26 +190,188 @@ func handleLogin(c *gin.Context) {
 		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
 		return
 	}
+	// удалённый пользователь неотличим от несуществующего
+	if user != nil && user.DeletedAt != nil {
+		user = nil
+	}
+	userID := ""
+	if user != nil {
+		userID = user.ID
+	}
+
+	now := time.Now()
+	block, err := checkLoginAllowed(req.Email, c.ClientIP(), now)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to check login attempts")
+		return
+	}
+	if block != nil {
+		recordLogin(c, req.Email, userID, loginMethodPassword, block.code, "")
+		block.respond(c)
+		return
+	}
+
 	if user == nil || !checkPassword(user.PasswordHash, req.Password) {
+		block, err := recordLoginFailure(c, req.Email, userID, now)
+		if err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to record login attempt")
+			return
+		}
+		if block != nil {
+			recordLogin(c, req.Email, userID, loginMethodPassword, block.code, "")
+			block.respond(c)
+			return
+		}
+		recordLogin(c, req.Email, userID, loginMethodPassword, "INVALID_CREDENTIALS", "")
 		fail(c, http.StatusUnauthorized, "INVALID_CREDENTIALS", "Email or password is incorrect")
 		return
 	}
 
-	token, err := generateToken(user)
+	if err := resetLoginFailures(req.Email); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to reset login attempts")
+		return
+	}
+
+	// об отключении сообщаем только после верного пароля
+	if !user.Active {
+		recordLogin(c, req.Email, user.ID, loginMethodPassword, "ACCOUNT_DISABLED", "")
+		fail(c, http.StatusForbidden, "ACCOUNT_DISABLED", "Account is disabled")
+		return
+	}
+	if emailVerificationBlocksLogin(user) {
+		recordLogin(c, req.Email, user.ID, loginMethodPassword, "EMAIL_NOT_VERIFIED", "")
+		fail(c, http.StatusForbidden, "EMAIL_NOT_VERIFIED", "Email address is not verified")
+		return
+	}
+	// с 2FA токены выдаст только второй шаг — POST /v1/users/login/mfa,
+	// в журнал попадёт его результат
+	if user.MFAEnabled {
+		startMFAChallenge(c, user)
+		return
+	}
+
+	// новый логин — новая цепочка refresh-токенов
+	sessionID := uuid.NewString()
+	tokens, err := issueTokens(c, user, sessionID)
 	if err != nil {
 		fail(c, http.StatusInternalServerError, "TOKEN_ERROR", "Failed to generate token")
 		return
 	}
+	recordLogin(c, req.Email, user.ID, loginMethodPassword, "", sessionID)
 
-	success(c, gin.H{
-		"token": token,
-		"user": gin.H{
-			"id":    user.ID,
-			"email": user.Email,
-			"name":  user.Name,
-			"roles": user.Roles,
-		},
-	})
+	tokens["user"] = gin.H{
+		"id":    user.ID,
+		"email": user.Email,
+		"name":  user.Name,
+		"roles": user.Roles,
+	}
+	success(c, tokens)
+}
+
+// короткоживущий access-токен + новый refresh-токен в цепочке familyID;
+// заодно создаём сессию или отмечаем её активность
+func issueTokens(c *gin.Context, user *User, familyID string) (gin.H, error) {
+	accessToken, claims, err := generateToken(user, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshPlain, refreshHash, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &RefreshToken{
+		ID:              uuid.NewString(),
+		FamilyID:        familyID,
+		UserID:          user.ID,
+		TokenHash:       refreshHash,
+		ExpiresAt:       time.Now().Add(refreshTokenTTL),
+		AccessJTI:       claims.ID,
+		AccessExpiresAt: claims.ExpiresAt.Time,
+	}
+	if err := insertRefreshToken(rt); err != nil {
+		return nil, err
+	}
+	if err := upsertSession(&Session{
+		ID:        familyID,
+		UserID:    user.ID,
+		IP:        c.ClientIP(),
+		UserAgent: userAgent(c),
+		LastIP:    c.ClientIP(),
+	}); err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":            accessToken,
+		"expiresIn":        int(accessTokenTTL.Seconds()),
+		"refreshToken":     refreshPlain,
+		"refreshExpiresAt": rt.ExpiresAt,
+	}, nil
+}
+
+// POST /v1/users/token/refresh
+func handleRefreshToken(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	rt, err := getRefreshTokenByHash(hashOpaqueToken(req.RefreshToken))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query refresh token")
+		return
+	}
+	if rt == nil || rt.RevokedAt != nil || time.Now().After(rt.ExpiresAt) {
+		fail(c, http.StatusUnauthorized, "INVALID_REFRESH_TOKEN", "Refresh token is invalid or expired")
+		return
+	}
+
+	// повторное предъявление уже использованного токена — признак кражи:
+	// отзываем всю цепочку, владельцу придётся залогиниться заново
+	ok, err := markRefreshTokenUsed(rt.ID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to update refresh token")
+		return
+	}
+	if !ok {
+		if err := revokeRefreshTokenFamily(rt.FamilyID); err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to revoke refresh tokens")
+			return
+		}
+		log.Printf("requestId=%s refresh token reuse detected userId=%s family=%s, family revoked",
+			getRequestID(c), rt.UserID, rt.FamilyID)
+		fail(c, http.StatusUnauthorized, "REFRESH_TOKEN_REUSED", "Refresh token was already used, all sessions of this login are revoked")
+		return
+	}
+
+	// роли могли поменяться — берём пользователя из БД
+	user, err := getUserByID(rt.UserID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return
+	}
+	if user == nil || user.DeletedAt != nil {
+		fail(c, http.StatusUnauthorized, "INVALID_REFRESH_TOKEN", "Refresh token is invalid or expired")
+		return
+	}
+	if !user.Active {
+		fail(c, http.StatusForbidden, "ACCOUNT_DISABLED", "Account is disabled")
+		return
+	}
+	if emailVerificationBlocksLogin(user) {
+		fail(c, http.StatusForbidden, "EMAIL_NOT_VERIFIED", "Email address is not verified")
+		return
+	}
+
+	tokens, err := issueTokens(c, user, rt.FamilyID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "TOKEN_ERROR", "Failed to generate token")
+		return
+	}
+	success(c, tokens)
 }
 
 // GET /v1/users/me
@@ -155,13 +393,22 @@ func handleMe(c *gin.Context) {
 		return
 	}
 
+	_, permissions, err := userAccess(user)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query permissions")
+		return
+	}
+
 	success(c, gin.H{
-		"id":        user.ID,
-		"email":     user.Email,
-		"name":      user.Name,
-		"roles":     user.Roles,
-		"createdAt": user.CreatedAt,
-		"updatedAt": user.UpdatedAt,
+		"id":            user.ID,
+		"email":         user.Email,
+		"name":          user.Name,
+		"roles":         user.Roles,
+		"permissions":   permissions,
+		"emailVerified": user.EmailVerified,
+		"mfaEnabled":    user.MFAEnabled,
+		"createdAt":     user.CreatedAt,
+		"updatedAt":     user.UpdatedAt,
 	})
 }
 
@@ -206,21 +453,7 @@ func handleGetUsers(c *gin.Context) {
 	email := c.Query("email")
 	role := c.Query("role")
 
-	pageStr := c.DefaultQuery("page", "1")
-	limitStr := c.DefaultQuery("limit", "10")
-
-	page, err := strconv.Atoi(pageStr)
-	if err != nil || page < 1 {
-		page = 1
-	}
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit < 1 {
-		limit = 10
-	}
-	if limit > 100 {
-		limit = 100
-	}
-	offset := (page - 1) * limit
+	page, limit, offset := pagination(c)
 
 	total, err := getUsersCountFiltered(email, role)
 	if err != nil {
@@ -236,14 +469,7 @@ func handleGetUsers(c *gin.Context) {
 
 	items := make([]gin.H, 0, len(users))
 	for _, u := range users {
-		items = append(items, gin.H{
-			"id":        u.ID,
-			"email":     u.Email,
-			"name":      u.Name,
-			"roles":     u.Roles,
-			"createdAt": u.CreatedAt,
-			"updatedAt": u.UpdatedAt,
-		})
+		items = append(items, adminUserView(u))
 	}
 
 	success(c, gin.H{
@@ -253,3 +479,12 @@ func handleGetUsers(c *gin.Context) {
 		"total": total,
 	})
 }
+
+// GET /healthz — проба для api_gateway
+func handleHealthz(c *gin.Context) {
+	if err := db.Ping(); err != nil {
+		fail(c, http.StatusServiceUnavailable, "DB_UNAVAILABLE", "Database is not available")
+		return
+	}
+	success(c, gin.H{"status": "ok"})
+}
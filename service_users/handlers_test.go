@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ключи подписи генерируются в каталоге теста
+func initTestSigningKeys(t *testing.T) {
+	t.Helper()
+	prev := jwtKeysDir
+	jwtKeysDir = "keys"
+	t.Cleanup(func() { jwtKeysDir = prev })
+	if err := initSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func refreshRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/users/token/refresh", handleRefreshToken)
+	return router
+}
+
+func issueTestRefreshToken(t *testing.T, u *User) string {
+	t.Helper()
+	tokens, err := issueTokens(testContext("10.0.0.1"), u, uuid.NewString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens["refreshToken"].(string)
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	openTestDB(t)
+	initTestSigningKeys(t)
+	u := createTestUser(t, "bob@example.com", "engineer")
+	router := refreshRouter()
+	refresh := func(token string) (int, string, map[string]any) {
+		return doJSON(t, router, http.MethodPost, "/v1/users/token/refresh", RefreshRequest{RefreshToken: token}, nil)
+	}
+
+	first := issueTestRefreshToken(t, u)
+	status, code, data := refresh(first)
+	if status != http.StatusOK {
+		t.Fatalf("rotation: got %d %s, want 200", status, code)
+	}
+	second, _ := data["refreshToken"].(string)
+	if second == "" || second == first {
+		t.Fatalf("rotation returned refresh token %q, want a new one", second)
+	}
+
+	// старый токен предъявлен повторно — вся цепочка отзывается
+	if status, code, _ := refresh(first); status != http.StatusUnauthorized || code != "REFRESH_TOKEN_REUSED" {
+		t.Fatalf("reuse: got %d %s, want 401 REFRESH_TOKEN_REUSED", status, code)
+	}
+	rt, err := getRefreshTokenByHash(hashOpaqueToken(second))
+	if err != nil || rt == nil || rt.RevokedAt == nil {
+		t.Fatalf("rotated token after reuse: %+v (err %v), want revoked", rt, err)
+	}
+	if status, code, _ := refresh(second); status != http.StatusUnauthorized || code != "INVALID_REFRESH_TOKEN" {
+		t.Fatalf("rotated token after reuse: got %d %s, want 401 INVALID_REFRESH_TOKEN", status, code)
+	}
+}
+
+func TestExpiredRefreshTokenRejected(t *testing.T) {
+	openTestDB(t)
+	initTestSigningKeys(t)
+	u := createTestUser(t, "bob@example.com", "engineer")
+
+	prev := refreshTokenTTL
+	refreshTokenTTL = -time.Minute
+	token := issueTestRefreshToken(t, u)
+	refreshTokenTTL = prev
+
+	status, code, _ := doJSON(t, refreshRouter(), http.MethodPost, "/v1/users/token/refresh", RefreshRequest{RefreshToken: token}, nil)
+	if status != http.StatusUnauthorized || code != "INVALID_REFRESH_TOKEN" {
+		t.Fatalf("got %d %s, want 401 INVALID_REFRESH_TOKEN", status, code)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type CreateInviteRequest struct {
+	Role  string `json:"role" binding:"required"`
+	Email string `json:"email" binding:"omitempty,email"` // пусто — приглашение для любого адреса
+	TTL   string `json:"ttl"`                             // например "24h", по умолчанию INVITE_TTL
+}
+
+// проверить код приглашения при регистрации; false — ответ с ошибкой уже отправлен
+func checkInvite(c *gin.Context, code, email, role string) (*Invite, bool) {
+	inv, err := getInviteByHash(hashOpaqueToken(code))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query invite")
+		return nil, false
+	}
+	// чужой адрес не отличаем от неверного кода
+	if inv == nil || inv.UsedAt != nil || time.Now().After(inv.ExpiresAt) ||
+		(inv.Email != "" && !strings.EqualFold(inv.Email, email)) {
+		fail(c, http.StatusBadRequest, "INVALID_INVITE", "Invite code is invalid, expired or already used")
+		return nil, false
+	}
+	if inv.Role != role {
+		fail(c, http.StatusBadRequest, "INVITE_ROLE_MISMATCH", "Invite code is issued for role "+inv.Role)
+		return nil, false
+	}
+	return inv, true
+}
+
+// POST /v1/users/invites (admin) — код показывается только в этом ответе
+func handleCreateInvite(c *gin.Context) {
+	var req CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	role, ok := normalizeRole(req.Role)
+	if !ok {
+		fail(c, http.StatusBadRequest, "INVALID_ROLE",
+			"Role must be one of: "+roleNames())
+		return
+	}
+
+	ttl := inviteTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "ttl must be a positive duration, e.g. 24h")
+			return
+		}
+		ttl = d
+	}
+
+	code, hash, err := generateOpaqueToken()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "TOKEN_ERROR", "Failed to generate invite code")
+		return
+	}
+
+	inv := &Invite{
+		ID:        uuid.NewString(),
+		CodeHash:  hash,
+		Role:      role,
+		Email:     req.Email,
+		CreatedBy: c.GetString("userId"),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := insertInvite(inv); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to save invite")
+		return
+	}
+
+	writeAudit(c, "invite_created", "", inv.CreatedBy, "invite="+inv.ID+" role="+role)
+
+	success(c, gin.H{
+		"id":        inv.ID,
+		"code":      code,
+		"role":      inv.Role,
+		"email":     inv.Email,
+		"expiresAt": inv.ExpiresAt,
+	})
+}
+
+// GET /v1/users/invites (admin)
+func handleListInvites(c *gin.Context) {
+	page, limit, offset := pagination(c)
+
+	invites, err := listInvites(limit, offset)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to list invites")
+		return
+	}
+
+	success(c, gin.H{
+		"items": invites,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// GET /v1/users/role-requests?status=pending (admin)
+func handleListRoleRequests(c *gin.Context) {
+	status := c.DefaultQuery("status", roleRequestPending)
+	switch status {
+	case roleRequestPending, roleRequestApproved, roleRequestRejected:
+	case "all":
+		status = ""
+	default:
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "status must be one of: pending, approved, rejected, all")
+		return
+	}
+
+	page, limit, offset := pagination(c)
+
+	requests, err := listRoleRequests(status, limit, offset)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to list role requests")
+		return
+	}
+
+	success(c, gin.H{
+		"items": requests,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// POST /v1/users/role-requests/:requestId/approve (admin)
+func handleApproveRoleRequest(c *gin.Context) {
+	decideRoleRequestHandler(c, roleRequestApproved)
+}
+
+// POST /v1/users/role-requests/:requestId/reject (admin)
+func handleRejectRoleRequest(c *gin.Context) {
+	decideRoleRequestHandler(c, roleRequestRejected)
+}
+
+func decideRoleRequestHandler(c *gin.Context, status string) {
+	rr, err := getRoleRequestByID(c.Param("requestId"))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query role request")
+		return
+	}
+	if rr == nil {
+		fail(c, http.StatusNotFound, "ROLE_REQUEST_NOT_FOUND", "Role request not found")
+		return
+	}
+
+	user, err := getUserByID(rr.UserID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return
+	}
+	if user == nil {
+		fail(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+		return
+	}
+
+	actorID := c.GetString("userId")
+	decided, err := decideRoleRequest(rr.ID, status, actorID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to update role request")
+		return
+	}
+	if !decided {
+		fail(c, http.StatusConflict, "ROLE_REQUEST_DECIDED", "Role request is already "+rr.Status)
+		return
+	}
+
+	if status == roleRequestApproved && !hasRole(user.Roles, rr.Role) {
+		user, err = updateUserRoles(user.ID, append(user.Roles, rr.Role))
+		if err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to update user roles")
+			return
+		}
+		// роли зашиты в токены — старые сессии должны перелогиниться
+		if err := revokeAllUserSessions(user.ID, "roles_changed", getRequestID(c)); err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to revoke sessions")
+			return
+		}
+	}
+
+	writeAudit(c, "role_request_"+status, user.ID, actorID, "request="+rr.ID+" role="+rr.Role)
+
+	success(c, gin.H{
+		"id":     rr.ID,
+		"userId": user.ID,
+		"role":   rr.Role,
+		"status": status,
+		"roles":  user.Roles,
+	})
+}
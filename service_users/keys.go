@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ключ подписи JWT; kid = имя файла без .pem
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+var (
+	signingKeys = make(map[string]*signingKey)
+	activeKey   *signingKey
+)
+
+// загрузить все *.pem из jwtKeysDir. Подписываем активным ключом
+// (JWT_SIGNING_KEY_ID или последний по имени), публикуем в JWKS все —
+// так старые токены продолжают проверяться во время ротации.
+func initSigningKeys() error {
+	if err := os.MkdirAll(jwtKeysDir, 0o700); err != nil {
+		return err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(jwtKeysDir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	if len(paths) == 0 {
+		k, err := generateSigningKey(jwtKeysDir)
+		if err != nil {
+			return fmt.Errorf("generate signing key: %w", err)
+		}
+		log.Printf("No JWT signing keys in %s, generated new Ed25519 key kid=%s", jwtKeysDir, k.kid)
+		paths = []string{filepath.Join(jwtKeysDir, k.kid+".pem")}
+	}
+
+	kids := make([]string, 0, len(paths))
+	for _, p := range paths {
+		k, err := loadSigningKey(p)
+		if err != nil {
+			return fmt.Errorf("load %s: %w", p, err)
+		}
+		signingKeys[k.kid] = k
+		kids = append(kids, k.kid)
+	}
+	sort.Strings(kids)
+
+	activeKID := jwtSigningKeyID
+	if activeKID == "" {
+		activeKID = kids[len(kids)-1]
+	}
+	k, ok := signingKeys[activeKID]
+	if !ok {
+		return fmt.Errorf("signing key %q not found in %s", activeKID, jwtKeysDir)
+	}
+	activeKey = k
+
+	log.Printf("JWT keys loaded: %s, active kid=%s (%s)", strings.Join(kids, ","), k.kid, k.method.Alg())
+	return nil
+}
+
+func loadSigningKey(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+
+	switch key := parsed.(type) {
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, private: key}, nil
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("RSA key must be at least 2048 bits")
+		}
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, private: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+func generateSigningKey(dir string) (*signingKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := time.Now().UTC().Format("20060102T150405")
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0o600); err != nil {
+		return nil, err
+	}
+
+	return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, private: priv}, nil
+}
+
+// публичная часть ключа в формате JWK (RFC 7517 / RFC 8037)
+func publicJWK(k *signingKey) gin.H {
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	switch pub := k.private.Public().(type) {
+	case ed25519.PublicKey:
+		return gin.H{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   b64(pub),
+			"kid": k.kid,
+			"alg": k.method.Alg(),
+			"use": "sig",
+		}
+	case *rsa.PublicKey:
+		return gin.H{
+			"kty": "RSA",
+			"n":   b64(pub.N.Bytes()),
+			"e":   b64(big.NewInt(int64(pub.E)).Bytes()),
+			"kid": k.kid,
+			"alg": k.method.Alg(),
+			"use": "sig",
+		}
+	default:
+		return nil
+	}
+}
+
+// GET /.well-known/jwks.json
+func handleJWKS(c *gin.Context) {
+	kids := make([]string, 0, len(signingKeys))
+	for kid := range signingKeys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keys := make([]gin.H, 0, len(kids))
+	for _, kid := range kids {
+		keys = append(keys, publicJWK(signingKeys[kid]))
+	}
+
+	// JWKS отдаём в стандартном виде, без обёртки success/data
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	loginScopeAccount = "account"
+	loginScopeIP      = "ip"
+)
+
+// почему вход сейчас запрещён и до какого момента
+type loginBlock struct {
+	status int
+	code   string
+	until  time.Time
+}
+
+func (b *loginBlock) respond(c *gin.Context) {
+	wait := time.Until(b.until)
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+
+	message := "Too many failed login attempts, try again later"
+	if b.code == "ACCOUNT_LOCKED" {
+		message = "Account is temporarily locked after too many failed login attempts"
+	}
+	failWithDetails(c, b.status, b.code, message, gin.H{"lockedUntil": b.until.UTC()})
+}
+
+// счётчик аккаунта ведём по email, даже если такого пользователя нет —
+// иначе по ответам можно было бы перебирать существующие адреса
+func loginAccountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// задержка перед следующей попыткой после failures неудач подряд
+func loginDelay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	d := loginDelayBase << (failures - 1)
+	if d <= 0 || d > loginDelayMax {
+		d = loginDelayMax
+	}
+	return d
+}
+
+// счётчик, актуальный на момент now: старые неудачи и истёкшая блокировка не считаются
+func activeLoginFailure(scope, key string, now time.Time) (*LoginFailure, error) {
+	f, err := getLoginFailure(scope, key)
+	if err != nil || f == nil {
+		return f, err
+	}
+	expireLoginFailure(f, now)
+	return f, nil
+}
+
+func expireLoginFailure(f *LoginFailure, now time.Time) {
+	if f.LockedUntil != nil && !now.Before(*f.LockedUntil) {
+		f.LockedUntil = nil
+		f.Failures = 0
+	}
+	if f.LockedUntil == nil && now.Sub(f.LastFailureAt) > loginFailureWindow {
+		f.Failures = 0
+	}
+}
+
+// можно ли сейчас пробовать войти. Проверяется до пароля: и блокировка,
+// и прогрессивная задержка действуют даже для верного пароля.
+func checkLoginAllowed(email, ip string, now time.Time) (*loginBlock, error) {
+	acc, err := activeLoginFailure(loginScopeAccount, loginAccountKey(email), now)
+	if err != nil {
+		return nil, err
+	}
+	if acc != nil {
+		if acc.LockedUntil != nil {
+			return &loginBlock{http.StatusLocked, "ACCOUNT_LOCKED", *acc.LockedUntil}, nil
+		}
+		if next := acc.LastFailureAt.Add(loginDelay(acc.Failures)); now.Before(next) {
+			return &loginBlock{http.StatusTooManyRequests, "TOO_MANY_ATTEMPTS", next}, nil
+		}
+	}
+
+	byIP, err := activeLoginFailure(loginScopeIP, ip, now)
+	if err != nil {
+		return nil, err
+	}
+	if byIP != nil && byIP.LockedUntil != nil {
+		return &loginBlock{http.StatusTooManyRequests, "TOO_MANY_ATTEMPTS", *byIP.LockedUntil}, nil
+	}
+	return nil, nil
+}
+
+// учесть неудачную попытку; если она привела к блокировке — вернуть её
+func recordLoginFailure(c *gin.Context, email, userID string, now time.Time) (*loginBlock, error) {
+	ip := c.ClientIP()
+
+	acc, err := bumpLoginFailure(c, loginScopeAccount, loginAccountKey(email), loginMaxFailures, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	byIP, err := bumpLoginFailure(c, loginScopeIP, ip, loginIPMaxFailures, "", now)
+	if err != nil {
+		return nil, err
+	}
+
+	if acc.LockedUntil != nil {
+		return &loginBlock{http.StatusLocked, "ACCOUNT_LOCKED", *acc.LockedUntil}, nil
+	}
+	if byIP.LockedUntil != nil {
+		return &loginBlock{http.StatusTooManyRequests, "TOO_MANY_ATTEMPTS", *byIP.LockedUntil}, nil
+	}
+	return nil, nil
+}
+
+func bumpLoginFailure(c *gin.Context, scope, key string, max int, userID string, now time.Time) (*LoginFailure, error) {
+	locked := false
+	f, err := updateLoginFailure(scope, key, func(f *LoginFailure) {
+		expireLoginFailure(f, now)
+		f.Failures++
+		f.LastFailureAt = now
+		if f.Failures >= max {
+			until := now.Add(loginLockout)
+			f.LockedUntil = &until
+			f.Failures = 0
+			locked = true
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if locked {
+		writeAudit(c, scope+"_locked", userID, "", fmt.Sprintf("%s=%s until=%s", scope, key, f.LockedUntil.UTC().Format(time.RFC3339)))
+	}
+	return f, nil
+}
+
+// успешный вход сбрасывает счётчик аккаунта; счётчик IP — нет,
+// иначе его можно обнулять, перемежая подбор входами в свой аккаунт
+func resetLoginFailures(email string) error {
+	_, err := deleteLoginFailure(loginScopeAccount, loginAccountKey(email))
+	return err
+}
+
+// ошибка записи аудита не должна ломать сам запрос — только логируем
+func writeAudit(c *gin.Context, event, userID, actorID, details string) {
+	e := &AuditEntry{
+		ID:        uuid.NewString(),
+		Event:     event,
+		UserID:    userID,
+		ActorID:   actorID,
+		IP:        c.ClientIP(),
+		RequestID: getRequestID(c),
+		Details:   details,
+	}
+	if err := insertAuditEntry(e); err != nil {
+		log.Printf("requestId=%s failed to write audit entry %s: %v", e.RequestID, event, err)
+		return
+	}
+	log.Printf("requestId=%s audit event=%s userId=%s actorId=%s ip=%s %s", e.RequestID, event, userID, actorID, e.IP, details)
+}
+
+// POST /v1/users/:id/unlock (admin) — снять блокировку входа и сбросить счётчик
+func handleUnlockUser(c *gin.Context) {
+	user, err := getUserByID(c.Param("id"))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return
+	}
+	if user == nil {
+		fail(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+		return
+	}
+
+	existed, err := deleteLoginFailure(loginScopeAccount, loginAccountKey(user.Email))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to unlock user")
+		return
+	}
+
+	writeAudit(c, "account_unlocked", user.ID, c.GetString("userId"), fmt.Sprintf("hadFailures=%t", existed))
+
+	success(c, gin.H{
+		"userId":   user.ID,
+		"unlocked": true,
+	})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// пустая БД во временном каталоге: dbPath — относительный путь
+func openTestDB(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := initDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+}
+
+// контекст запроса с заданного IP — для функций, которые пишут аудит
+func testContext(ip string) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/users/login", nil)
+	c.Request.RemoteAddr = ip + ":40000"
+	return c
+}
+
+func setLoginLimits(t *testing.T, account, ip int) {
+	t.Helper()
+	prevAccount, prevIP := loginMaxFailures, loginIPMaxFailures
+	loginMaxFailures, loginIPMaxFailures = account, ip
+	t.Cleanup(func() { loginMaxFailures, loginIPMaxFailures = prevAccount, prevIP })
+}
+
+func TestAccountLockedAfterMaxFailures(t *testing.T) {
+	openTestDB(t)
+	setLoginLimits(t, 3, 100)
+	c := testContext("10.0.0.1")
+	now := time.Now()
+
+	for i := 1; i < loginMaxFailures; i++ {
+		block, err := recordLoginFailure(c, "Bob@Example.com", "u1", now)
+		if err != nil || block != nil {
+			t.Fatalf("failure #%d: block=%v err=%v, want no block", i, block, err)
+		}
+	}
+	block, err := recordLoginFailure(c, "bob@example.com", "u1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block == nil || block.code != "ACCOUNT_LOCKED" || block.status != http.StatusLocked {
+		t.Fatalf("got %+v after %d failures, want ACCOUNT_LOCKED", block, loginMaxFailures)
+	}
+
+	// блокировка действует и для верного пароля, пока не истечёт
+	if block, _ := checkLoginAllowed("bob@example.com", "10.0.0.2", now.Add(loginLockout-time.Second)); block == nil || block.code != "ACCOUNT_LOCKED" {
+		t.Fatalf("got %+v before lockout expired, want ACCOUNT_LOCKED", block)
+	}
+	if block, err := checkLoginAllowed("bob@example.com", "10.0.0.2", now.Add(loginLockout)); err != nil || block != nil {
+		t.Fatalf("got block=%+v err=%v after lockout expired, want login allowed", block, err)
+	}
+
+	// после разблокировки счёт начинается заново
+	block, err = recordLoginFailure(c, "bob@example.com", "u1", now.Add(loginLockout))
+	if err != nil || block != nil {
+		t.Fatalf("first failure after unlock: block=%+v err=%v, want no block", block, err)
+	}
+}
+
+func TestFailureWindowRestartsCounter(t *testing.T) {
+	openTestDB(t)
+	setLoginLimits(t, 3, 100)
+	c := testContext("10.0.0.1")
+	now := time.Now()
+
+	for i := 1; i < loginMaxFailures; i++ {
+		if _, err := recordLoginFailure(c, "bob@example.com", "u1", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	later := now.Add(loginFailureWindow + time.Second)
+	if block, err := recordLoginFailure(c, "bob@example.com", "u1", later); err != nil || block != nil {
+		t.Fatalf("got block=%+v err=%v after the window passed, want no block", block, err)
+	}
+	f, err := getLoginFailure(loginScopeAccount, "bob@example.com")
+	if err != nil || f == nil || f.Failures != 1 {
+		t.Fatalf("got %+v (err %v), want 1 failure", f, err)
+	}
+}
+
+// с одного IP перебирают разные аккаунты: блокируется IP, а не аккаунты
+func TestIPLockedAcrossAccounts(t *testing.T) {
+	openTestDB(t)
+	setLoginLimits(t, 3, 4)
+	c := testContext("10.0.0.1")
+	now := time.Now()
+
+	var block *loginBlock
+	for i := 1; i <= loginIPMaxFailures; i++ {
+		var err error
+		if block, err = recordLoginFailure(c, fmt.Sprintf("user%d@example.com", i), "", now); err != nil {
+			t.Fatal(err)
+		}
+		if i < loginIPMaxFailures && block != nil {
+			t.Fatalf("failure #%d: got %+v, want no block", i, block)
+		}
+	}
+	if block == nil || block.code != "TOO_MANY_ATTEMPTS" || block.status != http.StatusTooManyRequests {
+		t.Fatalf("got %+v, want TOO_MANY_ATTEMPTS", block)
+	}
+
+	if block, _ := checkLoginAllowed("fresh@example.com", "10.0.0.1", now); block == nil || block.code != "TOO_MANY_ATTEMPTS" {
+		t.Errorf("got %+v for a new account from the locked IP, want TOO_MANY_ATTEMPTS", block)
+	}
+	if block, err := checkLoginAllowed("fresh@example.com", "10.0.0.2", now); err != nil || block != nil {
+		t.Errorf("got block=%+v err=%v from another IP, want login allowed", block, err)
+	}
+}
+
+func TestSuccessfulLoginResetsAccountCounterOnly(t *testing.T) {
+	openTestDB(t)
+	setLoginLimits(t, 3, 100)
+	c := testContext("10.0.0.1")
+	now := time.Now()
+
+	for i := 1; i < loginMaxFailures; i++ {
+		if _, err := recordLoginFailure(c, "bob@example.com", "u1", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := resetLoginFailures("BOB@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if f, err := getLoginFailure(loginScopeAccount, "bob@example.com"); err != nil || f != nil {
+		t.Errorf("account counter: got %+v (err %v), want it deleted", f, err)
+	}
+	if f, err := getLoginFailure(loginScopeIP, "10.0.0.1"); err != nil || f == nil || f.Failures != loginMaxFailures-1 {
+		t.Errorf("ip counter: got %+v (err %v), want %d failures kept", f, err, loginMaxFailures-1)
+	}
+}
+
+// параллельные неудачи не должны затирать друг другу счёт
+func TestConcurrentFailuresAreAllCounted(t *testing.T) {
+	openTestDB(t)
+	const n = 50
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := bumpLoginFailure(testContext("10.0.0.1"), loginScopeAccount, "bob@example.com", n+1, "u1", now); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	f, err := getLoginFailure(loginScopeAccount, "bob@example.com")
+	if err != nil || f == nil || f.Failures != n {
+		t.Fatalf("got %+v (err %v), want %d failures", f, err, n)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string // text/plain
+}
+
+// отправка писем; реализация выбирается MAIL_DRIVER
+type Mailer interface {
+	Send(msg MailMessage) error
+}
+
+var mailer Mailer
+
+func initMailer() error {
+	switch mailDriver {
+	case "smtp":
+		if smtpAddr == "" {
+			return fmt.Errorf("SMTP_ADDR is required for MAIL_DRIVER=smtp")
+		}
+		mailer = &smtpMailer{addr: smtpAddr, username: smtpUsername, password: smtpPassword, from: mailFrom}
+	case "file":
+		if err := os.MkdirAll(mailDir, 0o700); err != nil {
+			return err
+		}
+		mailer = &fileMailer{dir: mailDir, from: mailFrom}
+	case "memory":
+		// письма никуда не уходят — только для тестов
+		log.Println("WARNING: MAIL_DRIVER=memory, emails are not delivered")
+		mailer = &memoryMailer{limit: memoryMailerLimit}
+	default:
+		return fmt.Errorf("unknown MAIL_DRIVER %q (smtp, file, memory)", mailDriver)
+	}
+	log.Println("Mailer initialized:", mailDriver)
+	return nil
+}
+
+// письмо в формате RFC 5322 (без вложений)
+func formatMail(from string, msg MailMessage) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// SMTP-сервер; авторизация PLAIN, если задан логин (net/smtp сам включает STARTTLS)
+type smtpMailer struct {
+	addr     string
+	username string
+	password string
+	from     string
+}
+
+func (m *smtpMailer) Send(msg MailMessage) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		host, _, err := net.SplitHostPort(m.addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.username, m.password, host)
+	}
+	return smtp.SendMail(m.addr, auth, m.from, []string{msg.To}, formatMail(m.from, msg))
+}
+
+// складывает письма .eml-файлами в каталог — для локальной разработки
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+func (m *fileMailer) Send(msg MailMessage) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), uuid.NewString())
+	return os.WriteFile(filepath.Join(m.dir, name), formatMail(m.from, msg), 0o600)
+}
+
+// сколько последних писем держит memoryMailer: forgot и resend доступны без
+// авторизации, память процесса не должна расти от них бесконечно
+const memoryMailerLimit = 100
+
+// держит последние limit писем в памяти процесса — для тестов
+type memoryMailer struct {
+	mu    sync.Mutex
+	limit int
+	sent  []MailMessage
+}
+
+func (m *memoryMailer) Send(msg MailMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.limit > 0 && len(m.sent) >= m.limit {
+		m.sent = append(m.sent[:0], m.sent[len(m.sent)-m.limit+1:]...)
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// отправленные письма (копия)
+func (m *memoryMailer) Messages() []MailMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]MailMessage(nil), m.sent...)
+}
+
+// отправка в фоне: ответ клиенту не должен зависеть от почтового сервера
+// (и по времени ответа нельзя понять, ушло ли письмо)
+func sendMailAsync(msg MailMessage, requestID string) {
+	go func() {
+		if err := mailer.Send(msg); err != nil {
+			log.Printf("requestId=%s failed to send mail %q: %v", requestID, msg.Subject, err)
+			return
+		}
+		log.Printf("requestId=%s mail %q sent", requestID, msg.Subject)
+	}()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemoryMailerKeepsLastMessages(t *testing.T) {
+	m := &memoryMailer{limit: 3}
+	for i := 1; i <= 5; i++ {
+		if err := m.Send(MailMessage{To: fmt.Sprintf("u%d@example.com", i), Subject: "s", Body: "b"}); err != nil {
+			t.Fatalf("send #%d: %v", i, err)
+		}
+	}
+
+	got := m.Messages()
+	if len(got) != 3 {
+		t.Fatalf("got %d messages, want 3", len(got))
+	}
+	for i, want := range []string{"u3@example.com", "u4@example.com", "u5@example.com"} {
+		if got[i].To != want {
+			t.Errorf("message #%d to %q, want %q", i, got[i].To, want)
+		}
+	}
+}
+
+func TestFileMailerWritesEML(t *testing.T) {
+	dir := t.TempDir()
+	m := &fileMailer{dir: dir, from: "no-reply@localhost"}
+	if err := m.Send(MailMessage{To: "u@example.com", Subject: "Reset", Body: "line1\nline2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("got files %v (err %v), want one .eml", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"From: no-reply@localhost\r\n", "To: u@example.com\r\n", "Subject: Reset\r\n", "\r\n\r\nline1\r\nline2"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("message has no %q:\n%s", want, data)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,13 +14,43 @@ func main() {
 		log.Fatalf("failed to init database: %v", err)
 	}
 
+	// политика нужна уже для пароля первого админа
+	if err := initPasswordPolicy(); err != nil {
+		log.Fatalf("failed to init password policy: %v", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "bootstrap-admin" {
+		runBootstrapAdminCommand(os.Args[2:])
+		return
+	}
+	if err := bootstrapAdminFromEnv(); err != nil {
+		log.Fatalf("failed to bootstrap admin: %v", err)
+	}
+
+	if err := initMailer(); err != nil {
+		log.Fatalf("failed to init mailer: %v", err)
+	}
+
+	if err := initSigningKeys(); err != nil {
+		log.Fatalf("failed to init JWT signing keys: %v", err)
+	}
+
 	router := gin.New()
+	// X-Forwarded-For от клиента в обход шлюза не учитываем
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
 	router.Use(
 		gin.Recovery(),
 		RequestIDMiddleware(),
 		LoggingMiddleware(),
 	)
 
+	router.GET("/healthz", handleHealthz)
+	router.GET("/.well-known/jwks.json", handleJWKS)
+	router.GET("/internal/revocations", InternalRequired(), handleListRevocations)
+	router.POST("/internal/api-keys/verify", InternalRequired(), handleVerifyAPIKey)
+
 	api := router.Group("/v1")
 	{
 		users := api.Group("/users")
@@ -27,12 +58,50 @@ func main() {
 			// публичные
 			users.POST("/register", handleRegister)
 			users.POST("/login", handleLogin)
+			users.POST("/login/mfa", handleLoginMFA)
+			users.POST("/token/refresh", handleRefreshToken)
+			users.POST("/password/forgot", handleForgotPassword)
+			users.POST("/password/reset", handleResetPassword)
+			users.GET("/verify", handleVerifyEmail)
+			users.POST("/verify/resend", handleResendVerification)
 
 			// защищённые
 			users.Use(AuthRequired())
 			users.GET("/me", handleMe)
 			users.PATCH("/me", handleUpdateProfile)
-			users.GET("", AdminRequired(), handleGetUsers)
+			users.POST("/me/password", handleChangePassword)
+			users.GET("/me/2fa", handleMFAStatus)
+			users.POST("/me/2fa/setup", handleMFASetup)
+			users.POST("/me/2fa/confirm", handleMFAConfirm)
+			users.POST("/me/2fa/disable", handleMFADisable)
+			users.POST("/me/2fa/recovery-codes", handleMFARecoveryCodes)
+			users.POST("/me/api-keys", handleCreateAPIKey)
+			users.GET("/me/api-keys", handleListAPIKeys)
+			users.DELETE("/me/api-keys/:keyId", handleRevokeAPIKey)
+			users.GET("/me/sessions", handleListMySessions)
+			users.DELETE("/me/sessions/:sessionId", handleTerminateMySession)
+			users.POST("/logout", handleLogout)
+
+			// управление пользователями
+			admin := users.Group("", PermissionRequired(permUsersManage))
+			{
+				admin.GET("", handleGetUsers)
+				admin.GET("/:id", handleGetUser)
+				admin.PATCH("/:id", handleAdminUpdateUser)
+				admin.DELETE("/:id", handleDeleteUser)
+				admin.GET("/:id/sessions", handleListUserSessions)
+				admin.DELETE("/:id/sessions/:sessionId", handleTerminateUserSession)
+				admin.POST("/:id/sessions/revoke", handleRevokeUserSessions)
+				admin.GET("/:id/logins", handleListUserLogins)
+				admin.POST("/:id/unlock", handleUnlockUser)
+				admin.POST("/invites", handleCreateInvite)
+				admin.GET("/invites", handleListInvites)
+				admin.GET("/roles", handleListRoles)
+				admin.PATCH("/roles/:role", handleUpdateRole)
+				admin.GET("/role-requests", handleListRoleRequests)
+				admin.POST("/role-requests/:requestId/approve", handleApproveRoleRequest)
+				admin.POST("/role-requests/:requestId/reject", handleRejectRoleRequest)
+			}
 		}
 	}
 
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"crypto/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	recoveryCodesCount     = 10
+	mfaChallengeMaxAttempt = 5
+)
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"` // код из приложения или код восстановления
+}
+
+// отключение 2FA и новые коды восстановления: нужны пароль и код
+type MFAPasswordCodeRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type LoginMFARequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// загрузить текущего пользователя; nil — ответ с ошибкой уже отправлен
+func loadCurrentUser(c *gin.Context) *User {
+	user, err := getUserByID(c.GetString("userId"))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return nil
+	}
+	if user == nil || user.DeletedAt != nil {
+		fail(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+		return nil
+	}
+	return user
+}
+
+// коды восстановления вида xxxxx-xxxxx; в БД — только sha256 нормализованного кода
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodesCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes = append(codes, s[:5]+"-"+s[5:])
+		hashes = append(hashes, hashOpaqueToken(s))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// проверить второй фактор: TOTP-код или код восстановления (гасится).
+// method — чем подтвердили, для аудита.
+func verifySecondFactor(user *User, mfa *UserMFA, code string) (method string, ok bool, err error) {
+	code = strings.TrimSpace(code)
+	if step, valid := verifyTOTP(mfa.Secret, code, time.Now()); valid {
+		ok, err := useTOTPStep(user.ID, step)
+		return loginMethodTOTP, ok, err
+	}
+	ok, err = useRecoveryCode(user.ID, hashOpaqueToken(normalizeRecoveryCode(code)))
+	return loginMethodRecoveryCode, ok, err
+}
+
+// первый шаг логина пройден, но нужен код 2FA: выдаём challenge вместо токенов
+func startMFAChallenge(c *gin.Context, user *User) {
+	token, hash, err := generateOpaqueToken()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "TOKEN_ERROR", "Failed to generate MFA challenge")
+		return
+	}
+	ch := &MFAChallenge{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	}
+	if err := insertMFAChallenge(ch); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to save MFA challenge")
+		return
+	}
+
+	success(c, gin.H{
+		"mfaRequired":    true,
+		"challengeToken": token,
+		"expiresIn":      int(mfaChallengeTTL.Seconds()),
+	})
+}
+
+// POST /v1/users/login/mfa — второй шаг логина: challenge + код 2FA
+func handleLoginMFA(c *gin.Context) {
+	var req LoginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	ch, err := getMFAChallengeByHash(hashOpaqueToken(req.ChallengeToken))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query MFA challenge")
+		return
+	}
+	if ch == nil || ch.UsedAt != nil || time.Now().After(ch.ExpiresAt) || ch.Attempts >= mfaChallengeMaxAttempt {
+		fail(c, http.StatusUnauthorized, "INVALID_MFA_CHALLENGE", "MFA challenge is invalid or expired, log in again")
+		return
+	}
+
+	user, err := getUserByID(ch.UserID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return
+	}
+	if user == nil || user.DeletedAt != nil || !user.Active {
+		fail(c, http.StatusUnauthorized, "INVALID_MFA_CHALLENGE", "MFA challenge is invalid or expired, log in again")
+		return
+	}
+	mfa, err := getUserMFA(user.ID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query 2FA settings")
+		return
+	}
+	// 2FA выключили, пока challenge был жив
+	if mfa == nil || !mfa.Enabled {
+		fail(c, http.StatusUnauthorized, "INVALID_MFA_CHALLENGE", "MFA challenge is invalid or expired, log in again")
+		return
+	}
+
+	now := time.Now()
+	block, err := checkLoginAllowed(user.Email, c.ClientIP(), now)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to check login attempts")
+		return
+	}
+	if block != nil {
+		recordLogin(c, user.Email, user.ID, loginMethodTOTP, block.code, "")
+		block.respond(c)
+		return
+	}
+
+	method, ok, err := verifySecondFactor(user, mfa, req.Code)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to verify code")
+		return
+	}
+	if !ok {
+		if err := incrementMFAChallengeAttempts(ch.ID); err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to update MFA challenge")
+			return
+		}
+		block, err := recordLoginFailure(c, user.Email, user.ID, now)
+		if err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to record login attempt")
+			return
+		}
+		if block != nil {
+			recordLogin(c, user.Email, user.ID, loginMethodTOTP, block.code, "")
+			block.respond(c)
+			return
+		}
+		recordLogin(c, user.Email, user.ID, loginMethodTOTP, "INVALID_MFA_CODE", "")
+		fail(c, http.StatusUnauthorized, "INVALID_MFA_CODE", "Code is incorrect")
+		return
+	}
+
+	used, err := markMFAChallengeUsed(ch.ID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to update MFA challenge")
+		return
+	}
+	if !used {
+		fail(c, http.StatusUnauthorized, "INVALID_MFA_CHALLENGE", "MFA challenge is invalid or expired, log in again")
+		return
+	}
+	if err := resetLoginFailures(user.Email); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to reset login attempts")
+		return
+	}
+	if method == loginMethodRecoveryCode {
+		writeAudit(c, "mfa_recovery_code_used", user.ID, user.ID, "")
+	}
+
+	sessionID := uuid.NewString()
+	tokens, err := issueTokens(c, user, sessionID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "TOKEN_ERROR", "Failed to generate token")
+		return
+	}
+	recordLogin(c, user.Email, user.ID, method, "", sessionID)
+	tokens["user"] = gin.H{
+		"id":    user.ID,
+		"email": user.Email,
+		"name":  user.Name,
+		"roles": user.Roles,
+	}
+	success(c, tokens)
+}
+
+// POST /v1/users/me/2fa/setup — новый секрет; 2FA включится после confirm
+func handleMFASetup(c *gin.Context) {
+	user := loadCurrentUser(c)
+	if user == nil {
+		return
+	}
+	if user.MFAEnabled {
+		fail(c, http.StatusConflict, "MFA_ALREADY_ENABLED", "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "TOKEN_ERROR", "Failed to generate secret")
+		return
+	}
+	if err := saveMFASecret(user.ID, secret); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to save secret")
+		return
+	}
+
+	success(c, gin.H{
+		"secret":     secret,
+		"otpauthUri": totpURI(secret, user.Email),
+	})
+}
+
+// POST /v1/users/me/2fa/confirm — первый код из приложения включает 2FA.
+// Коды восстановления показываются только в этом ответе.
+func handleMFAConfirm(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	user := loadCurrentUser(c)
+	if user == nil {
+		return
+	}
+	mfa, err := getUserMFA(user.ID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query 2FA settings")
+		return
+	}
+	if mfa == nil {
+		fail(c, http.StatusBadRequest, "MFA_NOT_SET_UP", "Call 2fa/setup first")
+		return
+	}
+	if mfa.Enabled {
+		fail(c, http.StatusConflict, "MFA_ALREADY_ENABLED", "Two-factor authentication is already enabled")
+		return
+	}
+
+	step, ok := verifyTOTP(mfa.Secret, strings.TrimSpace(req.Code), time.Now())
+	if !ok {
+		fail(c, http.StatusBadRequest, "INVALID_MFA_CODE", "Code is incorrect")
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "TOKEN_ERROR", "Failed to generate recovery codes")
+		return
+	}
+	if err := enableMFA(user.ID, step, hashes); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to enable 2FA")
+		return
+	}
+	user.MFAEnabled = true
+
+	// старые сессии входили без второго фактора (и без ролей, требующих 2FA)
+	if err := revokeAllUserSessions(user.ID, "mfa_enabled", getRequestID(c)); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to revoke sessions")
+		return
+	}
+	writeAudit(c, "mfa_enabled", user.ID, user.ID, "")
+
+	tokens, err := issueTokens(c, user, uuid.NewString())
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "TOKEN_ERROR", "Failed to generate token")
+		return
+	}
+	tokens["recoveryCodes"] = codes
+	success(c, tokens)
+}
+
+// Подтвердить действие с 2FA паролем и кодом; false — ответ с ошибкой уже отправлен.
+// Неудачи идут в те же счётчики, что и логин: иначе украденным access-токеном
+// можно было бы подбирать код без ограничений.
+func checkPasswordAndCode(c *gin.Context, user *User, password, code string) (*UserMFA, bool) {
+	mfa, err := getUserMFA(user.ID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query 2FA settings")
+		return nil, false
+	}
+	if mfa == nil || !mfa.Enabled {
+		fail(c, http.StatusBadRequest, "MFA_NOT_ENABLED", "Two-factor authentication is not enabled")
+		return nil, false
+	}
+
+	now := time.Now()
+	block, err := checkLoginAllowed(user.Email, c.ClientIP(), now)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to check login attempts")
+		return nil, false
+	}
+	if block != nil {
+		block.respond(c)
+		return nil, false
+	}
+
+	passwordOK := checkPassword(user.PasswordHash, password)
+	codeOK := false
+	if passwordOK {
+		if _, codeOK, err = verifySecondFactor(user, mfa, code); err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to verify code")
+			return nil, false
+		}
+	}
+	if !passwordOK || !codeOK {
+		block, err := recordLoginFailure(c, user.Email, user.ID, now)
+		if err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to record login attempt")
+			return nil, false
+		}
+		if block != nil {
+			block.respond(c)
+			return nil, false
+		}
+		if !passwordOK {
+			fail(c, http.StatusBadRequest, "INVALID_CURRENT_PASSWORD", "Current password is incorrect")
+		} else {
+			fail(c, http.StatusBadRequest, "INVALID_MFA_CODE", "Code is incorrect")
+		}
+		return nil, false
+	}
+
+	if err := resetLoginFailures(user.Email); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to reset login attempts")
+		return nil, false
+	}
+	return mfa, true
+}
+
+// POST /v1/users/me/2fa/disable — нужны пароль и код (или код восстановления)
+func handleMFADisable(c *gin.Context) {
+	var req MFAPasswordCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	user := loadCurrentUser(c)
+	if user == nil {
+		return
+	}
+	if _, ok := checkPasswordAndCode(c, user, req.Password, req.Code); !ok {
+		return
+	}
+
+	if err := disableMFA(user.ID); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to disable 2FA")
+		return
+	}
+	// в действующих токенах могут быть роли, требующие 2FA
+	if err := revokeAllUserSessions(user.ID, "mfa_disabled", getRequestID(c)); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to revoke sessions")
+		return
+	}
+	writeAudit(c, "mfa_disabled", user.ID, user.ID, "")
+
+	success(c, gin.H{
+		"mfaEnabled": false,
+	})
+}
+
+// POST /v1/users/me/2fa/recovery-codes — новый набор кодов, старые перестают действовать;
+// нужны пароль и код
+func handleMFARecoveryCodes(c *gin.Context) {
+	var req MFAPasswordCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	user := loadCurrentUser(c)
+	if user == nil {
+		return
+	}
+	if _, ok := checkPasswordAndCode(c, user, req.Password, req.Code); !ok {
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "TOKEN_ERROR", "Failed to generate recovery codes")
+		return
+	}
+	if err := replaceRecoveryCodes(user.ID, hashes); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to save recovery codes")
+		return
+	}
+	writeAudit(c, "mfa_recovery_codes_regenerated", user.ID, user.ID, "")
+
+	success(c, gin.H{
+		"recoveryCodes": codes,
+	})
+}
+
+// GET /v1/users/me/2fa — состояние 2FA
+func handleMFAStatus(c *gin.Context) {
+	user := loadCurrentUser(c)
+	if user == nil {
+		return
+	}
+	remaining := 0
+	if user.MFAEnabled {
+		var err error
+		if remaining, err = countRecoveryCodes(user.ID); err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to count recovery codes")
+			return
+		}
+	}
+
+	success(c, gin.H{
+		"mfaEnabled":             user.MFAEnabled,
+		"recoveryCodesRemaining": remaining,
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func createTestUser(t *testing.T, email string, roles ...string) *User {
+	t.Helper()
+	hash, err := hashPassword("Passw0rd!1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &User{ID: uuid.NewString(), Email: email, Name: "Test", PasswordHash: hash, Roles: roles, EmailVerified: true}
+	if err := insertUser(u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// пользователь с включённой 2FA; возвращает ключ TOTP
+func enableTestMFA(t *testing.T, u *User, recoveryHashes ...string) []byte {
+	t.Helper()
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveMFASecret(u.ID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := enableMFA(u.ID, 0, recoveryHashes); err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// JSON-запрос к router; код ошибки из тела ответа (пусто при успехе)
+func doJSON(t *testing.T, router *gin.Engine, method, path string, body any, header http.Header) (int, string, map[string]any) {
+	t.Helper()
+	var resp struct {
+		Data  map[string]any `json:"data"`
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	status := doJSONInto(t, router, method, path, body, header, &resp)
+	return status, resp.Error.Code, resp.Data
+}
+
+// то же, но тело ответа разбирается в out
+func doJSONInto(t *testing.T, router *gin.Engine, method, path string, body any, header http.Header, out any) int {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+		t.Fatalf("%s %s: invalid response %q: %v", method, path, w.Body.String(), err)
+	}
+	return w.Code
+}
+
+func TestTOTPCodeIsSingleUse(t *testing.T) {
+	openTestDB(t)
+	u := createTestUser(t, "bob@example.com", "engineer")
+	key := enableTestMFA(t, u)
+	mfa, err := getUserMFA(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+	if method, ok, err := verifySecondFactor(u, mfa, code); err != nil || !ok || method != loginMethodTOTP {
+		t.Fatalf("first use: method=%s ok=%v err=%v, want totp accepted", method, ok, err)
+	}
+	if _, ok, err := verifySecondFactor(u, mfa, code); err != nil || ok {
+		t.Fatalf("replay: ok=%v err=%v, want rejected", ok, err)
+	}
+	// код предыдущего интервала ещё в окне, но после более позднего уже не принимается
+	previous := totpCode(key, time.Now().Unix()/totpPeriod-1)
+	if _, ok, err := verifySecondFactor(u, mfa, previous); err != nil || ok {
+		t.Fatalf("older step: ok=%v err=%v, want rejected", ok, err)
+	}
+}
+
+func TestRecoveryCodeIsSingleUse(t *testing.T) {
+	openTestDB(t)
+	u := createTestUser(t, "bob@example.com", "engineer")
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enableTestMFA(t, u, hashes...)
+	mfa, err := getUserMFA(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// регистр и дефис не важны
+	if method, ok, err := verifySecondFactor(u, mfa, strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))); err != nil || !ok || method != loginMethodRecoveryCode {
+		t.Fatalf("first use: method=%s ok=%v err=%v, want recovery code accepted", method, ok, err)
+	}
+	if _, ok, err := verifySecondFactor(u, mfa, codes[0]); err != nil || ok {
+		t.Fatalf("second use: ok=%v err=%v, want rejected", ok, err)
+	}
+	if n, err := countRecoveryCodes(u.ID); err != nil || n != len(codes)-1 {
+		t.Fatalf("got %d codes left (err %v), want %d", n, err, len(codes)-1)
+	}
+}
+
+// неверный код 2FA — такая же неудачная попытка входа, как неверный пароль
+func TestWrongMFACodeCountsTowardLockout(t *testing.T) {
+	openTestDB(t)
+	setLoginLimits(t, 2, 100)
+	prevBase, prevMax := loginDelayBase, loginDelayMax
+	loginDelayBase, loginDelayMax = 0, 0
+	t.Cleanup(func() { loginDelayBase, loginDelayMax = prevBase, prevMax })
+
+	u := createTestUser(t, "bob@example.com", "engineer")
+	key := enableTestMFA(t, u)
+	token, hash, err := generateOpaqueToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := &MFAChallenge{ID: uuid.NewString(), UserID: u.ID, TokenHash: hash, ExpiresAt: time.Now().Add(mfaChallengeTTL)}
+	if err := insertMFAChallenge(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/users/login/mfa", handleLoginMFA)
+	login := func(code string) (int, string) {
+		status, errCode, _ := doJSON(t, router, http.MethodPost, "/v1/users/login/mfa",
+			LoginMFARequest{ChallengeToken: token, Code: code}, nil)
+		return status, errCode
+	}
+
+	wrong := totpCode(key, time.Now().Unix()/totpPeriod+5)
+	if status, code := login(wrong); status != http.StatusUnauthorized || code != "INVALID_MFA_CODE" {
+		t.Fatalf("first wrong code: got %d %s, want 401 INVALID_MFA_CODE", status, code)
+	}
+	if status, code := login(wrong); status != http.StatusLocked || code != "ACCOUNT_LOCKED" {
+		t.Fatalf("second wrong code: got %d %s, want 423 ACCOUNT_LOCKED", status, code)
+	}
+	// блокировка действует и на верный код
+	right := totpCode(key, time.Now().Unix()/totpPeriod)
+	if status, code := login(right); status != http.StatusLocked || code != "ACCOUNT_LOCKED" {
+		t.Fatalf("right code while locked: got %d %s, want 423 ACCOUNT_LOCKED", status, code)
+	}
+}
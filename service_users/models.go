@@ -2,18 +2,50 @@ package main
 
 import (
 	"database/sql"
+	"sort"
 	"strings"
 	"time"
 )
 
 type User struct {
-	ID           string    `json:"id"`
-	Email        string    `json:"email"`
-	Name         string    `json:"name"`
-	PasswordHash string    `json:"-"`
-	Roles        []string  `json:"roles"`
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	ID           string   `json:"id"`
+	Email        string   `json:"email"`
+	Name         string   `json:"name"`
+	PasswordHash string   `json:"-"`
+	Roles        []string `json:"roles"`
+	Active       bool     `json:"active"`
+	// адрес подтверждён переходом по ссылке из письма
+	EmailVerified bool `json:"emailVerified"`
+	// включена ли двухфакторная аутентификация (TOTP)
+	MFAEnabled bool      `json:"mfaEnabled"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	// мягкое удаление: запись остаётся (email занят, история сохраняется)
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// роли собираем из user_roles в строку "admin,engineer"
+const userColumns = `id, email, name, password_hash,
+	COALESCE((SELECT GROUP_CONCAT(role) FROM user_roles WHERE user_id = users.id), ''),
+	active, email_verified,
+	COALESCE((SELECT enabled FROM user_mfa WHERE user_id = users.id), 0),
+	created_at, updated_at, deleted_at`
+
+func scanUser(row interface{ Scan(...any) error }) (*User, error) {
+	var u User
+	var rolesStr string
+	var deletedAt sql.NullTime
+
+	if err := row.Scan(&u.ID, &u.Email, &u.Name, &u.PasswordHash, &rolesStr, &u.Active,
+		&u.EmailVerified, &u.MFAEnabled, &u.CreatedAt, &u.UpdatedAt, &deletedAt); err != nil {
+		return nil, err
+	}
+	u.Roles = rolesFromString(rolesStr)
+	sort.Strings(u.Roles)
+	if deletedAt.Valid {
+		u.DeletedAt = &deletedAt.Time
+	}
+	return &u, nil
 }
 
 func rolesToString(roles []string) string {
@@ -35,68 +67,92 @@ func insertUser(u *User) error {
 	now := time.Now()
 	u.CreatedAt = now
 	u.UpdatedAt = now
+	u.Active = true
 
-	_, err := db.Exec(
-		`INSERT INTO users (id, email, name, password_hash, roles, created_at, updated_at)
-		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		u.ID, u.Email, u.Name, u.PasswordHash, rolesToString(u.Roles), u.CreatedAt, u.UpdatedAt,
-	)
-	return err
-}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-func getUserByEmail(email string) (*User, error) {
-	row := db.QueryRow(
-		`SELECT id, email, name, password_hash, roles, created_at, updated_at
-		 FROM users WHERE email = ?`,
-		email,
+	_, err = tx.Exec(
+		`INSERT INTO users (id, email, name, password_hash, active, email_verified, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		u.ID, u.Email, u.Name, u.PasswordHash, u.Active, u.EmailVerified, u.CreatedAt, u.UpdatedAt,
 	)
+	if err != nil {
+		return err
+	}
+	if err := insertUserRoles(tx, u.ID, u.Roles); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
-	var u User
-	var rolesStr string
-
-	if err := row.Scan(&u.ID, &u.Email, &u.Name, &u.PasswordHash, &rolesStr, &u.CreatedAt, &u.UpdatedAt); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
+func insertUserRoles(tx *sql.Tx, userID string, roles []string) error {
+	for _, r := range roles {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO user_roles (user_id, role) VALUES (?, ?)`, userID, r); err != nil {
+			return err
 		}
-		return nil, err
 	}
+	return nil
+}
 
-	u.Roles = rolesFromString(rolesStr)
-	return &u, nil
+func getUserByEmail(email string) (*User, error) {
+	u, err := scanUser(db.QueryRow(
+		`SELECT `+userColumns+` FROM users WHERE email = ?`,
+		email,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return u, err
 }
 
 func getUserByID(id string) (*User, error) {
-	row := db.QueryRow(
-		`SELECT id, email, name, password_hash, roles, created_at, updated_at
-		 FROM users WHERE id = ?`,
+	u, err := scanUser(db.QueryRow(
+		`SELECT `+userColumns+` FROM users WHERE id = ?`,
 		id,
-	)
-
-	var u User
-	var rolesStr string
-
-	if err := row.Scan(&u.ID, &u.Email, &u.Name, &u.PasswordHash, &rolesStr, &u.CreatedAt, &u.UpdatedAt); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, err
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
-
-	u.Roles = rolesFromString(rolesStr)
-	return &u, nil
+	return u, err
 }
 
-// число админов
+// число действующих админов
+const adminsCountQuery = `SELECT COUNT(*) FROM users
+	 WHERE active = 1 AND deleted_at IS NULL
+	   AND EXISTS (SELECT 1 FROM user_roles WHERE user_id = users.id AND role = 'admin')`
+
 func getAdminsCount() (int, error) {
-	row := db.QueryRow(`SELECT COUNT(*) FROM users WHERE roles LIKE '%admin%'`)
 	var count int
-	if err := row.Scan(&count); err != nil {
+	if err := db.QueryRow(adminsCountQuery).Scan(&count); err != nil {
 		return 0, err
 	}
 	return count, nil
 }
 
-// обновление профиля (сейчас только name)
+// выполнить change в tx и не дать ему убрать последнего действующего admin.
+// Счёт до и после — в той же транзакции, поэтому два параллельных запроса
+// не могут оба убрать «одного из двух».
+func keepLastAdminTx(tx *sql.Tx, change func() error) error {
+	var before, after int
+	if err := tx.QueryRow(adminsCountQuery).Scan(&before); err != nil {
+		return err
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	if err := tx.QueryRow(adminsCountQuery).Scan(&after); err != nil {
+		return err
+	}
+	if before > 0 && after == 0 {
+		return errLastAdmin
+	}
+	return nil
+}
+
 func updateUserProfile(id string, name string) (*User, error) {
 	now := time.Now()
 
@@ -111,9 +167,111 @@ func updateUserProfile(id string, name string) (*User, error) {
 	return getUserByID(id)
 }
 
+func updateUserRoles(id string, roles []string) (*User, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = keepLastAdminTx(tx, func() error {
+		if _, err := tx.Exec(`DELETE FROM user_roles WHERE user_id = ?`, id); err != nil {
+			return err
+		}
+		return insertUserRoles(tx, id, roles)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE users SET updated_at = ? WHERE id = ?`, time.Now(), id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return getUserByID(id)
+}
+
+// права, которые дают роли (без повторов, по алфавиту)
+func getPermissionsForRoles(roles []string) ([]string, error) {
+	perms := make([]string, 0)
+	if len(roles) == 0 {
+		return perms, nil
+	}
+
+	args := make([]any, len(roles))
+	for i, r := range roles {
+		args[i] = r
+	}
+	rows, err := db.Query(
+		`SELECT DISTINCT permission FROM role_permissions
+		 WHERE role IN (?`+strings.Repeat(", ?", len(roles)-1)+`) ORDER BY permission`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		perms = append(perms, p)
+	}
+	return perms, rows.Err()
+}
+
+func setUserActive(id string, active bool) (*User, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = keepLastAdminTx(tx, func() error {
+		_, err := tx.Exec(
+			`UPDATE users SET active = ?, updated_at = ? WHERE id = ?`,
+			active, time.Now(), id,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return getUserByID(id)
+}
+
+func softDeleteUser(id string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = keepLastAdminTx(tx, func() error {
+		now := time.Now()
+		_, err := tx.Exec(
+			`UPDATE users SET active = 0, deleted_at = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL`,
+			now, now, id,
+		)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 // список пользователей с фильтрами и пагинацией
 func getUsersCountFiltered(email, role string) (int, error) {
-	query := `SELECT COUNT(*) FROM users WHERE 1=1`
+	query := `SELECT COUNT(*) FROM users WHERE deleted_at IS NULL`
 	var args []any
 
 	if email != "" {
@@ -121,8 +279,9 @@ func getUsersCountFiltered(email, role string) (int, error) {
 		args = append(args, "%"+email+"%")
 	}
 	if role != "" {
-		query += ` AND roles LIKE ?`
-		args = append(args, "%"+role+"%")
+		// точное совпадение роли, а не подстрока
+		query += ` AND EXISTS (SELECT 1 FROM user_roles WHERE user_id = users.id AND role = ?)`
+		args = append(args, role)
 	}
 
 	row := db.QueryRow(query, args...)
@@ -134,11 +293,7 @@ func getUsersCountFiltered(email, role string) (int, error) {
 }
 
 func listUsersFiltered(email, role string, limit, offset int) ([]*User, error) {
-	query := `
-		SELECT id, email, name, password_hash, roles, created_at, updated_at
-		FROM users
-		WHERE 1=1
-	`
+	query := `SELECT ` + userColumns + ` FROM users WHERE deleted_at IS NULL`
 	var args []any
 
 	if email != "" {
@@ -146,8 +301,9 @@ func listUsersFiltered(email, role string, limit, offset int) ([]*User, error) {
 		args = append(args, "%"+email+"%")
 	}
 	if role != "" {
-		query += ` AND roles LIKE ?`
-		args = append(args, "%"+role+"%")
+		// точное совпадение роли, а не подстрока
+		query += ` AND EXISTS (SELECT 1 FROM user_roles WHERE user_id = users.id AND role = ?)`
+		args = append(args, role)
 	}
 
 	query += ` ORDER BY created_at DESC LIMIT ? OFFSET ?`
@@ -161,14 +317,11 @@ func listUsersFiltered(email, role string, limit, offset int) ([]*User, error) {
 
 	users := make([]*User, 0)
 	for rows.Next() {
-		var u User
-		var rolesStr string
-
-		if err := rows.Scan(&u.ID, &u.Email, &u.Name, &u.PasswordHash, &rolesStr, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		u, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		u.Roles = rolesFromString(rolesStr)
-		users = append(users, &u)
+		users = append(users, u)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -176,3 +329,1221 @@ func listUsersFiltered(email, role string, limit, offset int) ([]*User, error) {
 
 	return users, nil
 }
+
+type RefreshToken struct {
+	ID        string
+	FamilyID  string // все токены, полученные ротацией от одного логина
+	UserID    string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	// access-токен, выданный в паре с этим refresh
+	AccessJTI       string
+	AccessExpiresAt time.Time
+}
+
+func insertRefreshToken(t *RefreshToken) error {
+	t.CreatedAt = time.Now()
+
+	_, err := db.Exec(
+		`INSERT INTO refresh_tokens (id, family_id, user_id, token_hash, created_at, expires_at, access_jti, access_expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.FamilyID, t.UserID, t.TokenHash, t.CreatedAt, t.ExpiresAt, t.AccessJTI, t.AccessExpiresAt.UTC(),
+	)
+	return err
+}
+
+func getRefreshTokenByHash(hash string) (*RefreshToken, error) {
+	row := db.QueryRow(
+		`SELECT id, family_id, user_id, token_hash, created_at, expires_at, used_at, revoked_at
+		 FROM refresh_tokens WHERE token_hash = ?`,
+		hash,
+	)
+
+	var t RefreshToken
+	var usedAt, revokedAt sql.NullTime
+
+	if err := row.Scan(&t.ID, &t.FamilyID, &t.UserID, &t.TokenHash, &t.CreatedAt, &t.ExpiresAt, &usedAt, &revokedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if usedAt.Valid {
+		t.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return &t, nil
+}
+
+// пометить токен использованным; false — его уже успели использовать/отозвать
+func markRefreshTokenUsed(id string) (bool, error) {
+	res, err := db.Exec(
+		`UPDATE refresh_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL AND revoked_at IS NULL`,
+		time.Now(), id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// отзыв всей цепочки (при повторном использовании токена)
+func revokeRefreshTokenFamily(familyID string) error {
+	_, err := db.Exec(
+		`UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL`,
+		time.Now(), familyID,
+	)
+	return err
+}
+
+// отзыв всех refresh-токенов пользователя
+func revokeUserRefreshTokens(userID string) error {
+	_, err := db.Exec(
+		`UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`,
+		time.Now(), userID,
+	)
+	return err
+}
+
+// отозванный access-токен (хранится до истечения его срока)
+type RevokedToken struct {
+	JTI       string    `json:"jti"`
+	UserID    string    `json:"userId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// все токены пользователя, выпущенные не позже RevokedBefore, недействительны
+type UserRevocation struct {
+	UserID        string    `json:"userId"`
+	RevokedBefore time.Time `json:"revokedBefore"`
+}
+
+func insertRevokedToken(t *RevokedToken) error {
+	now := time.Now().UTC()
+
+	// заодно чистим записи, срок которых уже вышел
+	if _, err := db.Exec(`DELETE FROM revoked_tokens WHERE expires_at < ?`, now); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(
+		`INSERT OR IGNORE INTO revoked_tokens (jti, user_id, expires_at, revoked_at)
+		 VALUES (?, ?, ?, ?)`,
+		t.JTI, t.UserID, t.ExpiresAt.UTC(), now,
+	)
+	return err
+}
+
+func upsertUserRevocation(userID string) (*UserRevocation, error) {
+	now := time.Now().UTC()
+	// iat в JWT хранится с точностью до секунды: токены, выпущенные в ту же
+	// секунду, что и отзыв, остаются действительными (иначе сразу же
+	// полученный после отзыва токен тоже окажется отозванным)
+	revokedBefore := now.Truncate(time.Second)
+
+	_, err := db.Exec(
+		`INSERT INTO user_revocations (user_id, revoked_before, updated_at) VALUES (?, ?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET revoked_before = excluded.revoked_before, updated_at = excluded.updated_at`,
+		userID, revokedBefore, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &UserRevocation{UserID: userID, RevokedBefore: revokedBefore}, nil
+}
+
+func isTokenRevokedInDB(jti, userID string, issuedAt time.Time) (bool, error) {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?`, jti).Scan(&count); err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	var revokedBefore time.Time
+	err := db.QueryRow(`SELECT revoked_before FROM user_revocations WHERE user_id = ?`, userID).Scan(&revokedBefore)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return issuedAt.Before(revokedBefore), nil
+}
+
+// изменения денылиста после since (для синхронизации кэшей шлюза и service_orders)
+func listRevocationsSince(since time.Time) ([]RevokedToken, []UserRevocation, error) {
+	rows, err := db.Query(
+		`SELECT jti, user_id, expires_at FROM revoked_tokens WHERE revoked_at > ? AND expires_at > ?`,
+		since.UTC(), time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]RevokedToken, 0)
+	for rows.Next() {
+		var t RevokedToken
+		if err := rows.Scan(&t.JTI, &t.UserID, &t.ExpiresAt); err != nil {
+			return nil, nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	urows, err := db.Query(
+		`SELECT user_id, revoked_before FROM user_revocations WHERE updated_at > ?`,
+		since.UTC(),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer urows.Close()
+
+	users := make([]UserRevocation, 0)
+	for urows.Next() {
+		var u UserRevocation
+		if err := urows.Scan(&u.UserID, &u.RevokedBefore); err != nil {
+			return nil, nil, err
+		}
+		users = append(users, u)
+	}
+	if err := urows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return tokens, users, nil
+}
+
+// счётчик неудачных попыток входа по email или IP
+type LoginFailure struct {
+	Scope         string
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+const loginFailureQuery = `SELECT scope, key, failures, last_failure_at, locked_until
+	 FROM login_failures WHERE scope = ? AND key = ?`
+
+func getLoginFailure(scope, key string) (*LoginFailure, error) {
+	return scanLoginFailure(db.QueryRow(loginFailureQuery, scope, key))
+}
+
+func scanLoginFailure(row *sql.Row) (*LoginFailure, error) {
+	var f LoginFailure
+	var lockedUntil sql.NullTime
+	if err := row.Scan(&f.Scope, &f.Key, &f.Failures, &f.LastFailureAt, &lockedUntil); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		f.LockedUntil = &lockedUntil.Time
+	}
+	return &f, nil
+}
+
+// Изменить счётчик: чтение, fn и запись в одной транзакции, чтобы параллельные
+// неудачные попытки не затирали друг другу счёт. fn получает пустой счётчик,
+// если записи ещё нет.
+func updateLoginFailure(scope, key string, fn func(f *LoginFailure)) (*LoginFailure, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	f, err := scanLoginFailure(tx.QueryRow(loginFailureQuery, scope, key))
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		f = &LoginFailure{Scope: scope, Key: key}
+	}
+	fn(f)
+
+	var lockedUntil any
+	if f.LockedUntil != nil {
+		lockedUntil = f.LockedUntil.UTC()
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO login_failures (scope, key, failures, last_failure_at, locked_until) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(scope, key) DO UPDATE SET failures = excluded.failures,
+		   last_failure_at = excluded.last_failure_at, locked_until = excluded.locked_until`,
+		f.Scope, f.Key, f.Failures, f.LastFailureAt.UTC(), lockedUntil,
+	); err != nil {
+		return nil, err
+	}
+	return f, tx.Commit()
+}
+
+// true — запись была (то есть счётчик или блокировка действительно сброшены)
+func deleteLoginFailure(scope, key string) (bool, error) {
+	res, err := db.Exec(`DELETE FROM login_failures WHERE scope = ? AND key = ?`, scope, key)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// запись журнала аудита (блокировки, разблокировки и т.п.)
+type AuditEntry struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	UserID    string    `json:"userId"`
+	ActorID   string    `json:"actorId"`
+	IP        string    `json:"ip"`
+	RequestID string    `json:"requestId"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func insertAuditEntry(e *AuditEntry) error {
+	e.CreatedAt = time.Now().UTC()
+
+	_, err := db.Exec(
+		`INSERT INTO audit_log (id, event, user_id, actor_id, ip, request_id, details, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.Event, e.UserID, e.ActorID, e.IP, e.RequestID, e.Details, e.CreatedAt,
+	)
+	return err
+}
+
+// приглашение на роль; Email пустой — подойдёт любому адресу
+type Invite struct {
+	ID        string     `json:"id"`
+	CodeHash  string     `json:"-"`
+	Role      string     `json:"role"`
+	Email     string     `json:"email"`
+	CreatedBy string     `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+	UsedBy    string     `json:"usedBy"`
+}
+
+func insertInvite(inv *Invite) error {
+	inv.CreatedAt = time.Now().UTC()
+
+	_, err := db.Exec(
+		`INSERT INTO invites (id, code_hash, role, email, created_by, created_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		inv.ID, inv.CodeHash, inv.Role, inv.Email, inv.CreatedBy, inv.CreatedAt, inv.ExpiresAt.UTC(),
+	)
+	return err
+}
+
+func scanInvite(row interface{ Scan(...any) error }) (*Invite, error) {
+	var inv Invite
+	var usedAt sql.NullTime
+	var usedBy sql.NullString
+
+	if err := row.Scan(&inv.ID, &inv.CodeHash, &inv.Role, &inv.Email, &inv.CreatedBy,
+		&inv.CreatedAt, &inv.ExpiresAt, &usedAt, &usedBy); err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		inv.UsedAt = &usedAt.Time
+	}
+	inv.UsedBy = usedBy.String
+	return &inv, nil
+}
+
+func getInviteByHash(hash string) (*Invite, error) {
+	inv, err := scanInvite(db.QueryRow(
+		`SELECT id, code_hash, role, email, created_by, created_at, expires_at, used_at, used_by
+		 FROM invites WHERE code_hash = ?`,
+		hash,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return inv, err
+}
+
+func listInvites(limit, offset int) ([]*Invite, error) {
+	rows, err := db.Query(
+		`SELECT id, code_hash, role, email, created_by, created_at, expires_at, used_at, used_by
+		 FROM invites ORDER BY created_at DESC LIMIT ? OFFSET ?`,
+		limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := make([]*Invite, 0)
+	for rows.Next() {
+		inv, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, inv)
+	}
+	return invites, rows.Err()
+}
+
+// атомарно занять приглашение; false — его уже использовали
+func markInviteUsed(id, userID string) (bool, error) {
+	res, err := db.Exec(
+		`UPDATE invites SET used_at = ?, used_by = ? WHERE id = ? AND used_at IS NULL`,
+		time.Now().UTC(), userID, id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// вернуть приглашение, если пользователя так и не удалось создать
+func releaseInvite(id string) error {
+	_, err := db.Exec(`UPDATE invites SET used_at = NULL, used_by = NULL WHERE id = ?`, id)
+	return err
+}
+
+// статусы заявок на роль
+const (
+	roleRequestPending  = "pending"
+	roleRequestApproved = "approved"
+	roleRequestRejected = "rejected"
+)
+
+type RoleRequest struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"userId"`
+	Role      string     `json:"role"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"createdAt"`
+	DecidedAt *time.Time `json:"decidedAt"`
+	DecidedBy string     `json:"decidedBy"`
+}
+
+func insertRoleRequest(r *RoleRequest) error {
+	r.CreatedAt = time.Now().UTC()
+	r.Status = roleRequestPending
+
+	_, err := db.Exec(
+		`INSERT INTO role_requests (id, user_id, role, status, created_at) VALUES (?, ?, ?, ?, ?)`,
+		r.ID, r.UserID, r.Role, r.Status, r.CreatedAt,
+	)
+	return err
+}
+
+func scanRoleRequest(row interface{ Scan(...any) error }) (*RoleRequest, error) {
+	var r RoleRequest
+	var decidedAt sql.NullTime
+	var decidedBy sql.NullString
+
+	if err := row.Scan(&r.ID, &r.UserID, &r.Role, &r.Status, &r.CreatedAt, &decidedAt, &decidedBy); err != nil {
+		return nil, err
+	}
+	if decidedAt.Valid {
+		r.DecidedAt = &decidedAt.Time
+	}
+	r.DecidedBy = decidedBy.String
+	return &r, nil
+}
+
+func getRoleRequestByID(id string) (*RoleRequest, error) {
+	r, err := scanRoleRequest(db.QueryRow(
+		`SELECT id, user_id, role, status, created_at, decided_at, decided_by
+		 FROM role_requests WHERE id = ?`,
+		id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+func listRoleRequests(status string, limit, offset int) ([]*RoleRequest, error) {
+	query := `SELECT id, user_id, role, status, created_at, decided_at, decided_by
+		FROM role_requests WHERE 1=1`
+	var args []any
+
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY created_at ASC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := make([]*RoleRequest, 0)
+	for rows.Next() {
+		r, err := scanRoleRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, r)
+	}
+	return requests, rows.Err()
+}
+
+// решение по заявке; false — её уже рассмотрели
+func decideRoleRequest(id, status, decidedBy string) (bool, error) {
+	res, err := db.Exec(
+		`UPDATE role_requests SET status = ?, decided_at = ?, decided_by = ? WHERE id = ? AND status = ?`,
+		status, time.Now().UTC(), decidedBy, id, roleRequestPending,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// одноразовый токен сброса пароля (хранится только sha256)
+type PasswordReset struct {
+	ID        string
+	UserID    string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+func insertPasswordReset(r *PasswordReset) error {
+	r.CreatedAt = time.Now().UTC()
+
+	_, err := db.Exec(
+		`INSERT INTO password_resets (id, user_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		r.ID, r.UserID, r.TokenHash, r.CreatedAt, r.ExpiresAt.UTC(),
+	)
+	return err
+}
+
+func getPasswordResetByHash(hash string) (*PasswordReset, error) {
+	var r PasswordReset
+	var usedAt sql.NullTime
+
+	err := db.QueryRow(
+		`SELECT id, user_id, token_hash, created_at, expires_at, used_at FROM password_resets WHERE token_hash = ?`,
+		hash,
+	).Scan(&r.ID, &r.UserID, &r.TokenHash, &r.CreatedAt, &r.ExpiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		r.UsedAt = &usedAt.Time
+	}
+	return &r, nil
+}
+
+// атомарно погасить токен и сменить пароль; false — токен уже использован.
+// Остальные неиспользованные токены пользователя гасятся вместе с ним.
+func resetUserPassword(resetID string, user *User, passwordHash string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	res, err := tx.Exec(`UPDATE password_resets SET used_at = ? WHERE id = ? AND used_at IS NULL`, now, resetID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.Exec(`UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL`, now, user.ID); err != nil {
+		return false, err
+	}
+	if err := setPasswordTx(tx, user, passwordHash); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func updateUserPassword(user *User, passwordHash string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setPasswordTx(tx, user, passwordHash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// новый пароль; прежний хеш уходит в историю, лишняя история удаляется
+func setPasswordTx(tx *sql.Tx, user *User, passwordHash string) error {
+	now := time.Now().UTC()
+	if _, err := tx.Exec(
+		`INSERT INTO password_history (user_id, password_hash, created_at) VALUES (?, ?, ?)`,
+		user.ID, user.PasswordHash, now,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`DELETE FROM password_history WHERE user_id = ? AND id NOT IN
+		 (SELECT id FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?)`,
+		user.ID, user.ID, max(passwordHistorySize-1, 0),
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE users SET password_hash = ?, updated_at = ? WHERE id = ?`, passwordHash, now, user.ID); err != nil {
+		return err
+	}
+	user.PasswordHash = passwordHash
+	user.UpdatedAt = now
+	return nil
+}
+
+// последние limit прежних хешей пароля, новые первыми
+func getPasswordHistory(userID string, limit int) ([]string, error) {
+	rows, err := db.Query(
+		`SELECT password_hash FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?`,
+		userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, rows.Err()
+}
+
+// одноразовый токен подтверждения email (хранится только sha256)
+type EmailVerification struct {
+	ID        string
+	UserID    string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+func insertEmailVerification(v *EmailVerification) error {
+	v.CreatedAt = time.Now().UTC()
+
+	_, err := db.Exec(
+		`INSERT INTO email_verifications (id, user_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		v.ID, v.UserID, v.TokenHash, v.CreatedAt, v.ExpiresAt.UTC(),
+	)
+	return err
+}
+
+func getEmailVerificationByHash(hash string) (*EmailVerification, error) {
+	var v EmailVerification
+	var usedAt sql.NullTime
+
+	err := db.QueryRow(
+		`SELECT id, user_id, token_hash, created_at, expires_at, used_at FROM email_verifications WHERE token_hash = ?`,
+		hash,
+	).Scan(&v.ID, &v.UserID, &v.TokenHash, &v.CreatedAt, &v.ExpiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		v.UsedAt = &usedAt.Time
+	}
+	return &v, nil
+}
+
+// атомарно погасить токен и отметить адрес подтверждённым; false — токен уже использован.
+// Остальные неиспользованные токены пользователя гасятся вместе с ним.
+func verifyUserEmail(verificationID, userID string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	res, err := tx.Exec(`UPDATE email_verifications SET used_at = ? WHERE id = ? AND used_at IS NULL`, now, verificationID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.Exec(`UPDATE email_verifications SET used_at = ? WHERE user_id = ? AND used_at IS NULL`, now, userID); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`UPDATE users SET email_verified = 1, updated_at = ? WHERE id = ?`, now, userID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// TOTP-секрет пользователя; пока Enabled = false — ждёт подтверждения кодом
+type UserMFA struct {
+	UserID       string
+	Secret       string
+	Enabled      bool
+	LastUsedStep int64
+	CreatedAt    time.Time
+	EnabledAt    *time.Time
+}
+
+func getUserMFA(userID string) (*UserMFA, error) {
+	var m UserMFA
+	var enabledAt sql.NullTime
+
+	err := db.QueryRow(
+		`SELECT user_id, secret, enabled, last_used_step, created_at, enabled_at FROM user_mfa WHERE user_id = ?`,
+		userID,
+	).Scan(&m.UserID, &m.Secret, &m.Enabled, &m.LastUsedStep, &m.CreatedAt, &enabledAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enabledAt.Valid {
+		m.EnabledAt = &enabledAt.Time
+	}
+	return &m, nil
+}
+
+// новый секрет взамен неподтверждённого; включённую 2FA не трогает
+func saveMFASecret(userID, secret string) error {
+	_, err := db.Exec(
+		`INSERT INTO user_mfa (user_id, secret, enabled, last_used_step, created_at) VALUES (?, ?, 0, 0, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, created_at = excluded.created_at
+		 WHERE user_mfa.enabled = 0`,
+		userID, secret, time.Now().UTC(),
+	)
+	return err
+}
+
+// включить 2FA и заменить коды восстановления
+func enableMFA(userID string, step int64, recoveryHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`UPDATE user_mfa SET enabled = 1, enabled_at = ?, last_used_step = ? WHERE user_id = ?`,
+		time.Now().UTC(), step, userID,
+	); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodesTx(tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodesTx(tx *sql.Tx, userID string, hashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, h := range hashes {
+		if _, err := tx.Exec(
+			`INSERT INTO mfa_recovery_codes (code_hash, user_id, created_at) VALUES (?, ?, ?)`,
+			h, userID, now,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(userID string, hashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodesTx(tx, userID, hashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func disableMFA(userID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_mfa WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// занять интервал TOTP; false — код этого или более позднего интервала уже использован
+func useTOTPStep(userID string, step int64) (bool, error) {
+	res, err := db.Exec(
+		`UPDATE user_mfa SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?`,
+		step, userID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// погасить код восстановления; false — нет такого неиспользованного кода
+func useRecoveryCode(userID, hash string) (bool, error) {
+	res, err := db.Exec(
+		`UPDATE mfa_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		time.Now().UTC(), userID, hash,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func countRecoveryCodes(userID string) (int, error) {
+	var n int
+	err := db.QueryRow(
+		`SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID,
+	).Scan(&n)
+	return n, err
+}
+
+// второй шаг логина: выдаётся после верного пароля, меняется на токены по коду 2FA
+type MFAChallenge struct {
+	ID        string
+	UserID    string
+	TokenHash string
+	Attempts  int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+func insertMFAChallenge(ch *MFAChallenge) error {
+	ch.CreatedAt = time.Now().UTC()
+
+	_, err := db.Exec(
+		`INSERT INTO mfa_challenges (id, user_id, token_hash, attempts, created_at, expires_at) VALUES (?, ?, ?, 0, ?, ?)`,
+		ch.ID, ch.UserID, ch.TokenHash, ch.CreatedAt, ch.ExpiresAt.UTC(),
+	)
+	return err
+}
+
+func getMFAChallengeByHash(hash string) (*MFAChallenge, error) {
+	var ch MFAChallenge
+	var usedAt sql.NullTime
+
+	err := db.QueryRow(
+		`SELECT id, user_id, token_hash, attempts, created_at, expires_at, used_at FROM mfa_challenges WHERE token_hash = ?`,
+		hash,
+	).Scan(&ch.ID, &ch.UserID, &ch.TokenHash, &ch.Attempts, &ch.CreatedAt, &ch.ExpiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		ch.UsedAt = &usedAt.Time
+	}
+	return &ch, nil
+}
+
+func incrementMFAChallengeAttempts(id string) error {
+	_, err := db.Exec(`UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = ?`, id)
+	return err
+}
+
+// атомарно погасить challenge; false — его уже использовали
+func markMFAChallengeUsed(id string) (bool, error) {
+	res, err := db.Exec(`UPDATE mfa_challenges SET used_at = ? WHERE id = ? AND used_at IS NULL`, time.Now().UTC(), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// роль в ответах админского API
+type RoleInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	MFARequired bool     `json:"mfaRequired"`
+	Permissions []string `json:"permissions"`
+}
+
+func listRoles() ([]*RoleInfo, error) {
+	rows, err := db.Query(`SELECT name, description, mfa_required FROM roles ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make([]*RoleInfo, 0)
+	for rows.Next() {
+		var r RoleInfo
+		if err := rows.Scan(&r.Name, &r.Description, &r.MFARequired); err != nil {
+			return nil, err
+		}
+		roles = append(roles, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, r := range roles {
+		if r.Permissions, err = getPermissionsForRoles([]string{r.Name}); err != nil {
+			return nil, err
+		}
+	}
+	return roles, nil
+}
+
+// false — такой роли нет
+func setRoleMFARequired(role string, required bool) (bool, error) {
+	res, err := db.Exec(`UPDATE roles SET mfa_required = ? WHERE name = ?`, required, role)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// роли, которые попадают в токен только при включённой 2FA
+func getMFARequiredRoles() (map[string]bool, error) {
+	rows, err := db.Query(`SELECT name FROM roles WHERE mfa_required = 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	required := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		required[name] = true
+	}
+	return required, rows.Err()
+}
+
+// персональный API-ключ; Scopes — права, которые ключ может использовать
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, created_at, expires_at, last_used_at, revoked_at`
+
+func insertAPIKey(k *APIKey) error {
+	k.CreatedAt = time.Now().UTC()
+
+	_, err := db.Exec(
+		`INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		k.ID, k.UserID, k.Name, k.Prefix, k.KeyHash, strings.Join(k.Scopes, ","), k.CreatedAt, k.ExpiresAt.UTC(),
+	)
+	return err
+}
+
+func scanAPIKey(row interface{ Scan(...any) error }) (*APIKey, error) {
+	var k APIKey
+	var scopes string
+	var lastUsedAt, revokedAt sql.NullTime
+
+	if err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.KeyHash, &scopes,
+		&k.CreatedAt, &k.ExpiresAt, &lastUsedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	k.Scopes = rolesFromString(scopes)
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return &k, nil
+}
+
+func getAPIKeyByHash(hash string) (*APIKey, error) {
+	k, err := scanAPIKey(db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`, hash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return k, err
+}
+
+func listUserAPIKeys(userID string) ([]*APIKey, error) {
+	rows, err := db.Query(
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = ? ORDER BY created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]*APIKey, 0)
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// сколько у пользователя действующих (не отозванных и не истёкших) ключей
+func countActiveAPIKeys(userID string) (int, error) {
+	var n int
+	err := db.QueryRow(
+		`SELECT COUNT(*) FROM api_keys WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?`,
+		userID, time.Now().UTC(),
+	).Scan(&n)
+	return n, err
+}
+
+// отозвать ключ пользователя; false — ключа нет или он уже отозван
+func revokeAPIKey(id, userID string) (bool, error) {
+	res, err := db.Exec(
+		`UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`,
+		time.Now().UTC(), id, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func touchAPIKey(id string) error {
+	_, err := db.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, time.Now().UTC(), id)
+	return err
+}
+
+// сессия (логин) пользователя; живёт, пока в цепочке есть действующий refresh-токен
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"-"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	LastIP     string    `json:"lastIp"`
+	Current    bool      `json:"current"`
+}
+
+// новая сессия или обновление последней активности при ротации refresh-токена
+func upsertSession(s *Session) error {
+	now := time.Now().UTC()
+	s.CreatedAt, s.LastSeenAt = now, now
+
+	_, err := db.Exec(
+		`INSERT INTO sessions (id, user_id, ip, user_agent, created_at, last_seen_at, last_ip)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(id) DO UPDATE SET last_seen_at = excluded.last_seen_at, last_ip = excluded.last_ip`,
+		s.ID, s.UserID, s.IP, s.UserAgent, s.CreatedAt, s.LastSeenAt, s.LastIP,
+	)
+	return err
+}
+
+// условие "в цепочке есть неиспользованный, неотозванный и не истёкший refresh-токен"
+const activeSessionCondition = `EXISTS (SELECT 1 FROM refresh_tokens r WHERE r.family_id = sessions.id
+	AND r.used_at IS NULL AND r.revoked_at IS NULL AND r.expires_at > ?)`
+
+func scanSession(row interface{ Scan(...any) error }) (*Session, error) {
+	var s Session
+	if err := row.Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.LastIP); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func listActiveSessions(userID string) ([]*Session, error) {
+	rows, err := db.Query(
+		`SELECT id, user_id, ip, user_agent, created_at, last_seen_at, last_ip FROM sessions
+		 WHERE user_id = ? AND `+activeSessionCondition+` ORDER BY last_seen_at DESC`,
+		userID, time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]*Session, 0)
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+func getActiveSession(userID, id string) (*Session, error) {
+	s, err := scanSession(db.QueryRow(
+		`SELECT id, user_id, ip, user_agent, created_at, last_seen_at, last_ip FROM sessions
+		 WHERE id = ? AND user_id = ? AND `+activeSessionCondition,
+		id, userID, time.Now(),
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
+}
+
+// завершить сессию: отозвать цепочку refresh-токенов и ещё не истёкшие
+// access-токены, выданные в ней
+func revokeSession(userID, sessionID string) error {
+	now := time.Now().UTC()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT access_jti, access_expires_at FROM refresh_tokens
+		 WHERE family_id = ? AND access_jti IS NOT NULL AND access_jti != '' AND access_expires_at > ?`,
+		sessionID, now,
+	)
+	if err != nil {
+		return err
+	}
+	var tokens []RevokedToken
+	for rows.Next() {
+		t := RevokedToken{UserID: userID}
+		if err := rows.Scan(&t.JTI, &t.ExpiresAt); err != nil {
+			rows.Close()
+			return err
+		}
+		tokens = append(tokens, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, t := range tokens {
+		if _, err := tx.Exec(
+			`INSERT OR IGNORE INTO revoked_tokens (jti, user_id, expires_at, revoked_at) VALUES (?, ?, ?, ?)`,
+			t.JTI, t.UserID, t.ExpiresAt.UTC(), now,
+		); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(
+		`UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL`,
+		now, sessionID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// попытка входа: Reason — код ошибки из ответа, пусто при успехе
+type LoginEvent struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	Success   bool      `json:"success"`
+	Method    string    `json:"method"`
+	Reason    string    `json:"reason"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	RequestID string    `json:"requestId"`
+	SessionID string    `json:"sessionId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func insertLoginEvent(e *LoginEvent) error {
+	e.CreatedAt = time.Now().UTC()
+
+	_, err := db.Exec(
+		`INSERT INTO login_events (id, user_id, email, success, method, reason, ip, user_agent, request_id, session_id, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.UserID, e.Email, e.Success, e.Method, e.Reason, e.IP, e.UserAgent, e.RequestID, e.SessionID, e.CreatedAt,
+	)
+	return err
+}
+
+func countLoginEvents(userID string) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM login_events WHERE user_id = ?`, userID).Scan(&n)
+	return n, err
+}
+
+func listLoginEvents(userID string, limit, offset int) ([]*LoginEvent, error) {
+	rows, err := db.Query(
+		`SELECT id, user_id, email, success, method, reason, ip, user_agent, request_id, session_id, created_at
+		 FROM login_events WHERE user_id = ? ORDER BY created_at DESC LIMIT ? OFFSET ?`,
+		userID, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*LoginEvent, 0)
+	for rows.Next() {
+		var e LoginEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Email, &e.Success, &e.Method, &e.Reason,
+			&e.IP, &e.UserAgent, &e.RequestID, &e.SessionID, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
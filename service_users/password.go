@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+func passwordResetMail(user *User, token string) MailMessage {
+	link := token
+	if passwordResetURL != "" {
+		link = passwordResetURL + token
+	}
+	return MailMessage{
+		To:      user.Email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Hello, %s!\n\n"+
+			"Someone requested a password reset for your account.\n"+
+			"Use this link or code within %s to set a new password:\n\n%s\n\n"+
+			"If it wasn't you, just ignore this email.\n",
+			user.Name, passwordResetTTL, link),
+	}
+}
+
+// POST /v1/users/password/forgot — ответ всегда одинаковый, чтобы по нему
+// нельзя было узнать, зарегистрирован ли адрес
+func handleForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	user, err := getUserByEmail(req.Email)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return
+	}
+
+	if user != nil && user.DeletedAt == nil && user.Active {
+		token, hash, err := generateOpaqueToken()
+		if err != nil {
+			fail(c, http.StatusInternalServerError, "TOKEN_ERROR", "Failed to generate reset token")
+			return
+		}
+		reset := &PasswordReset{
+			ID:        uuid.NewString(),
+			UserID:    user.ID,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(passwordResetTTL),
+		}
+		if err := insertPasswordReset(reset); err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to save reset token")
+			return
+		}
+
+		sendMailAsync(passwordResetMail(user, token), getRequestID(c))
+		writeAudit(c, "password_reset_requested", user.ID, "", "reset="+reset.ID)
+	} else {
+		log.Printf("requestId=%s password reset requested for unknown or inactive account", getRequestID(c))
+	}
+
+	success(c, gin.H{
+		"message": "If the account exists, password reset instructions have been sent",
+	})
+}
+
+// POST /v1/users/password/reset — новый пароль по токену из письма
+func handleResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	reset, err := getPasswordResetByHash(hashOpaqueToken(req.Token))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query reset token")
+		return
+	}
+	if reset == nil || reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		fail(c, http.StatusBadRequest, "INVALID_RESET_TOKEN", "Reset token is invalid, expired or already used")
+		return
+	}
+
+	user, err := getUserByID(reset.UserID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return
+	}
+	if user == nil || user.DeletedAt != nil || !user.Active {
+		fail(c, http.StatusBadRequest, "INVALID_RESET_TOKEN", "Reset token is invalid, expired or already used")
+		return
+	}
+
+	if !checkPasswordPolicy(c, req.Password, user) {
+		return
+	}
+
+	passwordHash, err := hashPassword(req.Password)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "HASH_ERROR", "Failed to hash password")
+		return
+	}
+
+	ok, err := resetUserPassword(reset.ID, user, passwordHash)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to reset password")
+		return
+	}
+	if !ok {
+		fail(c, http.StatusBadRequest, "INVALID_RESET_TOKEN", "Reset token is invalid, expired or already used")
+		return
+	}
+
+	// старый пароль мог быть скомпрометирован — все сессии закрываем
+	if err := revokeAllUserSessions(user.ID, "password_reset", getRequestID(c)); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to revoke sessions")
+		return
+	}
+	// владелец подтвердил доступ к почте — снимаем блокировку входа
+	if err := resetLoginFailures(user.Email); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to reset login attempts")
+		return
+	}
+
+	writeAudit(c, "password_reset", user.ID, user.ID, "reset="+reset.ID)
+
+	success(c, gin.H{
+		"userId": user.ID,
+		"reset":  true,
+	})
+}
+
+// POST /v1/users/me/password — смена пароля с подтверждением текущего.
+// Все сессии отзываются, в ответе новая пара токенов для этого клиента.
+func handleChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	user, err := getUserByID(c.GetString("userId"))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return
+	}
+	if user == nil || user.DeletedAt != nil {
+		fail(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+		return
+	}
+
+	// подбор текущего пароля с украденным токеном ограничиваем так же, как логин
+	now := time.Now()
+	block, err := checkLoginAllowed(user.Email, c.ClientIP(), now)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to check login attempts")
+		return
+	}
+	if block != nil {
+		block.respond(c)
+		return
+	}
+	if !checkPassword(user.PasswordHash, req.CurrentPassword) {
+		block, err := recordLoginFailure(c, user.Email, user.ID, now)
+		if err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to record login attempt")
+			return
+		}
+		if block != nil {
+			block.respond(c)
+			return
+		}
+		fail(c, http.StatusBadRequest, "INVALID_CURRENT_PASSWORD", "Current password is incorrect")
+		return
+	}
+
+	if !checkPasswordPolicy(c, req.NewPassword, user) {
+		return
+	}
+
+	passwordHash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "HASH_ERROR", "Failed to hash password")
+		return
+	}
+	if err := updateUserPassword(user, passwordHash); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to update password")
+		return
+	}
+	if err := resetLoginFailures(user.Email); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to reset login attempts")
+		return
+	}
+
+	if err := revokeAllUserSessions(user.ID, "password_changed", getRequestID(c)); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to revoke sessions")
+		return
+	}
+
+	writeAudit(c, "password_changed", user.ID, user.ID, "")
+
+	tokens, err := issueTokens(c, user, uuid.NewString())
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "TOKEN_ERROR", "Failed to generate token")
+		return
+	}
+	success(c, tokens)
+}
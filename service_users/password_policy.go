@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bcrypt учитывает только первые 72 байта пароля
+const passwordMaxBytes = 72
+
+// классы символов, которые можно потребовать в PASSWORD_REQUIRED_CLASSES
+var passwordClasses = map[string]struct {
+	message string
+	match   func(rune) bool
+}{
+	"lower":  {"Password must contain a lowercase letter", unicode.IsLower},
+	"upper":  {"Password must contain an uppercase letter", unicode.IsUpper},
+	"digit":  {"Password must contain a digit", unicode.IsDigit},
+	"symbol": {"Password must contain a symbol", func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) }},
+}
+
+// список по умолчанию; PASSWORD_DENYLIST_FILE подменяет его своим файлом
+//
+//go:embed common_passwords.txt
+var defaultPasswordDenylist []byte
+
+var passwordDenylist map[string]bool
+
+// одно нарушенное правило политики
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func initPasswordPolicy() error {
+	for _, class := range passwordRequiredClasses {
+		if _, ok := passwordClasses[class]; !ok {
+			return fmt.Errorf("unknown password class %q (lower, upper, digit, symbol)", class)
+		}
+	}
+
+	path, data := "embedded common_passwords.txt", defaultPasswordDenylist
+	if passwordDenylistPath != "" {
+		var err error
+		if data, err = os.ReadFile(passwordDenylistPath); err != nil {
+			return err
+		}
+		path = passwordDenylistPath
+	}
+
+	passwordDenylist = make(map[string]bool)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwordDenylist[strings.ToLower(line)] = true
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("Password policy: min length %d, classes %v, %d denied passwords from %s, history %d",
+		passwordMinLength, passwordRequiredClasses, len(passwordDenylist), path, passwordHistorySize)
+	return nil
+}
+
+// проверки, не зависящие от пользователя: длина, классы символов, deny-лист
+func passwordViolations(password string) []PasswordViolation {
+	violations := make([]PasswordViolation, 0)
+
+	if n := len([]rune(password)); n < passwordMinLength {
+		violations = append(violations, PasswordViolation{"min_length",
+			fmt.Sprintf("Password must be at least %d characters long", passwordMinLength)})
+	}
+	if len(password) > passwordMaxBytes {
+		violations = append(violations, PasswordViolation{"max_length",
+			fmt.Sprintf("Password must be at most %d bytes long", passwordMaxBytes)})
+	}
+	for _, class := range passwordRequiredClasses {
+		if !strings.ContainsFunc(password, passwordClasses[class].match) {
+			violations = append(violations, PasswordViolation{"require_" + class, passwordClasses[class].message})
+		}
+	}
+	if passwordDenylist[strings.ToLower(password)] {
+		violations = append(violations, PasswordViolation{"common_password", "Password is too common"})
+	}
+	return violations
+}
+
+// совпадает ли пароль с текущим или одним из последних в истории
+func passwordReused(user *User, password string) (bool, error) {
+	if passwordHistorySize <= 0 {
+		return false, nil
+	}
+	if checkPassword(user.PasswordHash, password) {
+		return true, nil
+	}
+	hashes, err := getPasswordHistory(user.ID, passwordHistorySize-1)
+	if err != nil {
+		return false, err
+	}
+	for _, h := range hashes {
+		if checkPassword(h, password) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// проверить пароль по политике; user — для проверки повторов (nil при регистрации).
+// false — ответ с ошибкой уже отправлен.
+func checkPasswordPolicy(c *gin.Context, password string, user *User) bool {
+	violations := passwordViolations(password)
+	if user != nil {
+		reused, err := passwordReused(user, password)
+		if err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to check password history")
+			return false
+		}
+		if reused {
+			violations = append(violations, PasswordViolation{"reused",
+				fmt.Sprintf("Password must differ from the last %d passwords", passwordHistorySize)})
+		}
+	}
+
+	if len(violations) > 0 {
+		failWithDetails(c, http.StatusBadRequest, "WEAK_PASSWORD", "Password does not meet the password policy",
+			gin.H{"violations": violations})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// политика по умолчанию со встроенным deny-листом
+func initTestPasswordPolicy(t *testing.T, classes ...string) {
+	t.Helper()
+	prevClasses, prevPath := passwordRequiredClasses, passwordDenylistPath
+	passwordRequiredClasses, passwordDenylistPath = classes, ""
+	t.Cleanup(func() { passwordRequiredClasses, passwordDenylistPath = prevClasses, prevPath })
+	if err := initPasswordPolicy(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func violationRules(violations []PasswordViolation) []string {
+	rules := make([]string, 0, len(violations))
+	for _, v := range violations {
+		rules = append(rules, v.Rule)
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+func TestPasswordViolations(t *testing.T) {
+	initTestPasswordPolicy(t, "lower", "upper", "digit", "symbol")
+
+	tests := []struct {
+		password string
+		rules    []string
+	}{
+		{"Str0ng!pass", nil},
+		{"S0!a", []string{"min_length"}},
+		{"STR0NG!PASS", []string{"require_lower"}},
+		{"str0ng!pass", []string{"require_upper"}},
+		{"Strong!pass", []string{"require_digit"}},
+		{"Str0ngpass", []string{"require_symbol"}},
+		{"", []string{"min_length", "require_digit", "require_lower", "require_symbol", "require_upper"}},
+		// длина считается в символах, а не байтах
+		{"Пароль1!", nil},
+		{"Aa1!" + strings.Repeat("x", passwordMaxBytes), []string{"max_length"}},
+		// встроенный deny-лист, без учёта регистра
+		{"password", []string{"common_password", "require_digit", "require_symbol", "require_upper"}},
+		{"Password1", []string{"common_password", "require_symbol"}},
+	}
+	for _, tt := range tests {
+		got := violationRules(passwordViolations(tt.password))
+		want := tt.rules
+		if want == nil {
+			want = []string{}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%q: got %v, want %v", tt.password, got, want)
+		}
+	}
+}
+
+func TestPasswordReusedFromHistory(t *testing.T) {
+	openTestDB(t)
+	initTestPasswordPolicy(t)
+	prev := passwordHistorySize
+	passwordHistorySize = 3
+	t.Cleanup(func() { passwordHistorySize = prev })
+
+	u := createTestUser(t, "bob@example.com", "engineer") // пароль Passw0rd!1
+	passwords := []string{"Second-pass1", "Third-pass1", "Fourth-pass1"}
+	for _, p := range passwords {
+		hash, err := hashPassword(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := updateUserPassword(u, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// помнятся текущий и два предыдущих
+	tests := map[string]bool{
+		"Fourth-pass1": true,
+		"Third-pass1":  true,
+		"Second-pass1": true,
+		"Passw0rd!1":   false,
+		"Brand-new1":   false,
+	}
+	for password, want := range tests {
+		if got, err := passwordReused(u, password); err != nil || got != want {
+			t.Errorf("%q: got reused=%v (err %v), want %v", password, got, err, want)
+		}
+	}
+}
+
+// в ответе перечислены все нарушенные правила, а не первое из них
+func TestChangePasswordReportsAllViolations(t *testing.T) {
+	openTestDB(t)
+	initTestPasswordPolicy(t, "lower", "upper", "digit", "symbol")
+	u := createTestUser(t, "bob@example.com", "engineer")
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/users/me/password", func(c *gin.Context) { c.Set("userId", u.ID) }, handleChangePassword)
+
+	tests := []struct {
+		password string
+		rules    []string
+	}{
+		{"password", []string{"common_password", "require_digit", "require_symbol", "require_upper"}},
+		{"abc", []string{"min_length", "require_digit", "require_symbol", "require_upper"}},
+		{"Passw0rd!1", []string{"reused"}},
+	}
+	for _, tt := range tests {
+		var resp struct {
+			Error struct {
+				Code       string              `json:"code"`
+				Violations []PasswordViolation `json:"violations"`
+			} `json:"error"`
+		}
+		status := doJSONInto(t, router, http.MethodPost, "/v1/users/me/password",
+			ChangePasswordRequest{CurrentPassword: "Passw0rd!1", NewPassword: tt.password}, nil, &resp)
+		if status != http.StatusBadRequest || resp.Error.Code != "WEAK_PASSWORD" {
+			t.Fatalf("%q: got %d %s, want 400 WEAK_PASSWORD", tt.password, status, resp.Error.Code)
+		}
+		if got := violationRules(resp.Error.Violations); !slices.Equal(got, tt.rules) {
+			t.Errorf("%q: got violations %v, want %v", tt.password, got, tt.rules)
+		}
+		for _, v := range resp.Error.Violations {
+			if v.Message == "" {
+				t.Errorf("%q: rule %s without a message", tt.password, v.Rule)
+			}
+		}
+	}
+}
@@ -22,3 +22,18 @@ func fail(c *gin.Context, status int, code, message string) {
 		},
 	})
 }
+
+// ошибка с дополнительными полями в error (например, lockedUntil)
+func failWithDetails(c *gin.Context, status int, code, message string, details gin.H) {
+	e := gin.H{
+		"code":    code,
+		"message": message,
+	}
+	for k, v := range details {
+		e[k] = v
+	}
+	c.JSON(status, gin.H{
+		"success": false,
+		"error":   e,
+	})
+}
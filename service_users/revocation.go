@@ -0,0 +1,142 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var notifyClient = &http.Client{Timeout: 3 * time.Second}
+
+func isTokenRevoked(claims *UserClaims) (bool, error) {
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	return isTokenRevokedInDB(claims.ID, claims.UserID, issuedAt)
+}
+
+// отозвать все токены пользователя: access по времени выпуска, refresh — в БД
+func revokeAllUserSessions(userID, reason, requestID string) error {
+	if _, err := upsertUserRevocation(userID); err != nil {
+		return err
+	}
+	if err := revokeUserRefreshTokens(userID); err != nil {
+		return err
+	}
+
+	log.Printf("requestId=%s all sessions revoked userId=%s reason=%s", requestID, userID, reason)
+	notifyRevocation()
+	return nil
+}
+
+// push-уведомление подписчикам (шлюз, service_orders): они сразу перечитывают
+// денылист, не дожидаясь очередного опроса
+func notifyRevocation() {
+	for _, url := range revocationNotifyURLs {
+		go func(url string) {
+			req, err := http.NewRequest(http.MethodPost, url, nil)
+			if err != nil {
+				log.Printf("revocation notify %s failed: %v", url, err)
+				return
+			}
+			req.Header.Set("X-Internal-Token", string(internalAuthSecret))
+
+			resp, err := notifyClient.Do(req)
+			if err != nil {
+				log.Printf("revocation notify %s failed: %v", url, err)
+				return
+			}
+			resp.Body.Close()
+		}(url)
+	}
+}
+
+// POST /v1/users/logout — отзыв текущего access-токена и его цепочки refresh-токенов
+func handleLogout(c *gin.Context) {
+	claims, err := parseToken(bearerToken(c))
+	if err != nil {
+		fail(c, http.StatusUnauthorized, "INVALID_TOKEN", "Bearer token is required to log out")
+		return
+	}
+
+	expiresAt := time.Now().Add(accessTokenTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	if err := insertRevokedToken(&RevokedToken{
+		JTI:       claims.ID,
+		UserID:    claims.UserID,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to revoke token")
+		return
+	}
+
+	if claims.SessionID != "" {
+		if err := revokeRefreshTokenFamily(claims.SessionID); err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to revoke refresh tokens")
+			return
+		}
+	}
+
+	notifyRevocation()
+
+	success(c, gin.H{"loggedOut": true})
+}
+
+// POST /v1/users/:id/sessions/revoke (admin)
+func handleRevokeUserSessions(c *gin.Context) {
+	userID := c.Param("id")
+
+	user, err := getUserByID(userID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return
+	}
+	if user == nil {
+		fail(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+		return
+	}
+
+	if err := revokeAllUserSessions(user.ID, "admin_request", getRequestID(c)); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to revoke sessions")
+		return
+	}
+
+	success(c, gin.H{
+		"userId":  user.ID,
+		"revoked": true,
+	})
+}
+
+// GET /internal/revocations?since=RFC3339 — изменения денылиста для кэшей
+func handleListRevocations(c *gin.Context) {
+	// фиксируем момент до запроса, чтобы ничего не потерять между опросами
+	now := time.Now().UTC()
+
+	var since time.Time
+	if s := c.Query("since"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "since must be RFC3339 timestamp")
+			return
+		}
+		since = t
+	}
+
+	tokens, users, err := listRevocationsSince(since)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to list revocations")
+		return
+	}
+
+	success(c, gin.H{
+		"tokens": tokens,
+		"users":  users,
+		"now":    now.Format(time.RFC3339Nano),
+	})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// права в формате ресурс:действие[:область]; область own — только свои
+// объекты, any — любые
+const (
+	permOrdersCreate    = "orders:create"
+	permOrdersReadOwn   = "orders:read:own"
+	permOrdersReadAny   = "orders:read:any"
+	permOrdersUpdateOwn = "orders:update:own"
+	permOrdersUpdateAny = "orders:update:any"
+	permOrdersCancelOwn = "orders:cancel:own"
+	permOrdersCancelAny = "orders:cancel:any"
+	permOrdersDeleteOwn = "orders:delete:own"
+	permOrdersDeleteAny = "orders:delete:any"
+	permProductsManage  = "products:manage"
+	permUsersManage     = "users:manage"
+	permGatewayManage   = "gateway:manage"
+)
+
+type roleDefinition struct {
+	name        string
+	description string
+	permissions []string
+}
+
+// встроенные роли и их права; при старте добавляются в таблицы roles и
+// role_permissions, если их там нет. Дополнительные права можно выдать
+// вставкой в role_permissions — они не затираются.
+var defaultRoles = []roleDefinition{
+	{"customer", "Заказчик", []string{
+		permOrdersCreate, permOrdersReadOwn, permOrdersCancelOwn, permOrdersDeleteOwn,
+	}},
+	{"engineer", "Инженер", []string{
+		permOrdersCreate, permOrdersReadOwn, permOrdersUpdateOwn, permOrdersCancelOwn, permOrdersDeleteOwn,
+	}},
+	{"director", "Директор", []string{
+		permOrdersCreate, permOrdersReadAny, permOrdersCancelOwn, permOrdersDeleteOwn,
+	}},
+	{"manager", "Менеджер", []string{
+		permOrdersCreate, permOrdersReadAny, permOrdersUpdateAny, permOrdersCancelAny, permOrdersDeleteAny,
+		permProductsManage,
+	}},
+	{"admin", "Администратор", []string{
+		permOrdersCreate, permOrdersReadAny, permOrdersUpdateAny, permOrdersCancelAny, permOrdersDeleteAny,
+		permProductsManage, permUsersManage, permGatewayManage,
+	}},
+}
+
+func normalizeRole(role string) (string, bool) {
+	role = strings.ToLower(strings.TrimSpace(role))
+	for _, r := range defaultRoles {
+		if r.name == role {
+			return role, true
+		}
+	}
+	return "", false
+}
+
+func roleNames() string {
+	names := make([]string, 0, len(defaultRoles))
+	for _, r := range defaultRoles {
+		names = append(names, r.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func seedRoles(d *sql.DB) error {
+	for _, r := range defaultRoles {
+		if _, err := d.Exec(`INSERT OR IGNORE INTO roles (name, description) VALUES (?, ?)`, r.name, r.description); err != nil {
+			return err
+		}
+		for _, p := range r.permissions {
+			if _, err := d.Exec(`INSERT OR IGNORE INTO role_permissions (role, permission) VALUES (?, ?)`, r.name, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// перенос ролей из старой колонки users.roles ("engineer,admin") в user_roles
+func migrateRolesColumn(d *sql.DB) error {
+	ok, err := hasColumn(d, "users", "roles")
+	if err != nil || !ok {
+		return err
+	}
+
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, roles FROM users`)
+	if err != nil {
+		return err
+	}
+	type userRoles struct{ id, roles string }
+	var all []userRoles
+	for rows.Next() {
+		var ur userRoles
+		if err := rows.Scan(&ur.id, &ur.roles); err != nil {
+			rows.Close()
+			return err
+		}
+		all = append(all, ur)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, ur := range all {
+		for _, r := range strings.Split(ur.roles, ",") {
+			role, ok := normalizeRole(r)
+			if !ok {
+				if strings.TrimSpace(r) != "" {
+					log.Printf("DB migration: user %s has unknown role %q, skipped", ur.id, r)
+				}
+				continue
+			}
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO user_roles (user_id, role) VALUES (?, ?)`, ur.id, role); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec(`ALTER TABLE users DROP COLUMN roles`); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("DB migration: moved roles of %d users to user_roles", len(all))
+	return nil
+}
+
+type UpdateRoleRequest struct {
+	MFARequired *bool `json:"mfaRequired" binding:"required"`
+}
+
+// GET /v1/users/roles (admin) — роли, их права и требование 2FA
+func handleListRoles(c *gin.Context) {
+	roles, err := listRoles()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to list roles")
+		return
+	}
+	success(c, gin.H{
+		"items": roles,
+	})
+}
+
+// PATCH /v1/users/roles/:role (admin) — требовать ли 2FA для роли.
+// Действует с ближайшей выдачи токенов (логин или refresh).
+func handleUpdateRole(c *gin.Context) {
+	var req UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	role := c.Param("role")
+	ok, err := setRoleMFARequired(role, *req.MFARequired)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to update role")
+		return
+	}
+	if !ok {
+		fail(c, http.StatusNotFound, "ROLE_NOT_FOUND", "Role not found")
+		return
+	}
+
+	writeAudit(c, "role_updated", "", c.GetString("userId"), fmt.Sprintf("role=%s mfaRequired=%t", role, *req.MFARequired))
+
+	success(c, gin.H{
+		"name":        role,
+		"mfaRequired": *req.MFARequired,
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// способ входа в журнале
+const (
+	loginMethodPassword     = "password"
+	loginMethodTOTP         = "totp"
+	loginMethodRecoveryCode = "recovery_code"
+)
+
+const userAgentMaxLen = 512
+
+func userAgent(c *gin.Context) string {
+	ua := c.Request.UserAgent()
+	if len(ua) > userAgentMaxLen {
+		ua = ua[:userAgentMaxLen]
+	}
+	return ua
+}
+
+// записать попытку входа; reason — код ошибки из ответа (пусто — вход удался).
+// Как и аудит, ошибка записи не ломает сам логин.
+func recordLogin(c *gin.Context, email, userID, method, reason, sessionID string) {
+	e := &LoginEvent{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Email:     loginAccountKey(email),
+		Success:   reason == "",
+		Method:    method,
+		Reason:    reason,
+		IP:        c.ClientIP(),
+		UserAgent: userAgent(c),
+		RequestID: getRequestID(c),
+		SessionID: sessionID,
+	}
+	if err := insertLoginEvent(e); err != nil {
+		log.Printf("requestId=%s failed to record login event: %v", e.RequestID, err)
+	}
+}
+
+// сессии пользователя; текущая помечается по sid из Bearer-токена
+func listSessionsResponse(c *gin.Context, userID string) {
+	sessions, err := listActiveSessions(userID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to list sessions")
+		return
+	}
+
+	if claims, err := parseToken(bearerToken(c)); err == nil && claims.UserID == userID {
+		for _, s := range sessions {
+			s.Current = s.ID == claims.SessionID
+		}
+	}
+
+	success(c, gin.H{
+		"items": sessions,
+	})
+}
+
+// завершить сессию: refresh-цепочка и её access-токены отзываются сразу
+func terminateSession(c *gin.Context, userID, actorID string) {
+	sessionID := c.Param("sessionId")
+
+	s, err := getActiveSession(userID, sessionID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query session")
+		return
+	}
+	if s == nil {
+		fail(c, http.StatusNotFound, "SESSION_NOT_FOUND", "Session not found or already ended")
+		return
+	}
+
+	if err := revokeSession(userID, s.ID); err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to revoke session")
+		return
+	}
+	notifyRevocation()
+	writeAudit(c, "session_terminated", userID, actorID, "session="+s.ID)
+
+	success(c, gin.H{
+		"id":         s.ID,
+		"terminated": true,
+	})
+}
+
+// GET /v1/users/me/sessions
+func handleListMySessions(c *gin.Context) {
+	listSessionsResponse(c, c.GetString("userId"))
+}
+
+// DELETE /v1/users/me/sessions/:sessionId
+func handleTerminateMySession(c *gin.Context) {
+	userID := c.GetString("userId")
+	terminateSession(c, userID, userID)
+}
+
+// GET /v1/users/:id/sessions (admin)
+func handleListUserSessions(c *gin.Context) {
+	user, err := getUserByID(c.Param("id"))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return
+	}
+	if user == nil {
+		fail(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+		return
+	}
+	listSessionsResponse(c, user.ID)
+}
+
+// DELETE /v1/users/:id/sessions/:sessionId (admin)
+func handleTerminateUserSession(c *gin.Context) {
+	terminateSession(c, c.Param("id"), c.GetString("userId"))
+}
+
+// GET /v1/users/:id/logins?page=&limit= (admin) — журнал входов, новые сверху
+func handleListUserLogins(c *gin.Context) {
+	user, err := getUserByID(c.Param("id"))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return
+	}
+	if user == nil {
+		fail(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+		return
+	}
+
+	page, limit, offset := pagination(c)
+
+	total, err := countLoginEvents(user.ID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to count login events")
+		return
+	}
+	events, err := listLoginEvents(user.ID, limit, offset)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to list login events")
+		return
+	}
+
+	success(c, gin.H{
+		"items": events,
+		"page":  page,
+		"limit": limit,
+		"total": total,
+	})
+}
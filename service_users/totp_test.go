@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238, приложение B: SHA-1, ключ "12345678901234567890". В RFC коды
+// 8-значные, у нас 6 знаков — это младшие разряды тех же значений.
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string // в RFC
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		want := tt.code[len(tt.code)-totpDigits:]
+		if got := totpCode(key, tt.unix/totpPeriod); got != want {
+			t.Errorf("T=%d: got %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestVerifyTOTPWindow(t *testing.T) {
+	key := []byte("12345678901234567890")
+	secret := totpEncoding.EncodeToString(key)
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	for offset := int64(-3); offset <= 3; offset++ {
+		code := totpCode(key, current+offset)
+		step, ok := verifyTOTP(secret, code, now)
+		inWindow := offset >= -totpSkew && offset <= totpSkew
+		if ok != inWindow {
+			t.Errorf("offset %+d: got ok=%v, want %v", offset, ok, inWindow)
+		}
+		if ok && step != current+offset {
+			t.Errorf("offset %+d: got step %d, want %d", offset, step, current+offset)
+		}
+	}
+
+	// секрет в нижнем регистре (так его иногда вводят руками) тоже подходит
+	if _, ok := verifyTOTP(strings.ToLower(secret), totpCode(key, current), now); !ok {
+		t.Error("lower-case secret rejected")
+	}
+	if _, ok := verifyTOTP(secret, "12345", now); ok {
+		t.Error("short code accepted")
+	}
+}
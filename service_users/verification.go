@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+func emailVerificationMail(user *User, token string) MailMessage {
+	link := token
+	if emailVerificationURL != "" {
+		link = emailVerificationURL + token
+	}
+	return MailMessage{
+		To:      user.Email,
+		Subject: "Confirm your email",
+		Body: fmt.Sprintf("Hello, %s!\n\n"+
+			"Please confirm your email address within %s using this link or code:\n\n%s\n\n"+
+			"If you didn't create an account, just ignore this email.\n",
+			user.Name, emailVerificationTTL, link),
+	}
+}
+
+// выпустить токен подтверждения и отправить письмо
+func sendEmailVerification(c *gin.Context, user *User) error {
+	token, hash, err := generateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	v := &EmailVerification{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	}
+	if err := insertEmailVerification(v); err != nil {
+		return err
+	}
+
+	sendMailAsync(emailVerificationMail(user, token), getRequestID(c))
+	return nil
+}
+
+// нужно ли подтверждение, чтобы войти
+func emailVerificationBlocksLogin(user *User) bool {
+	return emailVerificationMode == "login" && !user.EmailVerified
+}
+
+// GET /v1/users/verify?token=... — ссылка из письма
+func handleVerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", "token is required")
+		return
+	}
+
+	v, err := getEmailVerificationByHash(hashOpaqueToken(token))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query verification token")
+		return
+	}
+	if v == nil || v.UsedAt != nil || time.Now().After(v.ExpiresAt) {
+		fail(c, http.StatusBadRequest, "INVALID_VERIFICATION_TOKEN", "Verification token is invalid, expired or already used")
+		return
+	}
+
+	user, err := getUserByID(v.UserID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return
+	}
+	if user == nil || user.DeletedAt != nil {
+		fail(c, http.StatusBadRequest, "INVALID_VERIFICATION_TOKEN", "Verification token is invalid, expired or already used")
+		return
+	}
+
+	ok, err := verifyUserEmail(v.ID, user.ID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to verify email")
+		return
+	}
+	if !ok {
+		fail(c, http.StatusBadRequest, "INVALID_VERIFICATION_TOKEN", "Verification token is invalid, expired or already used")
+		return
+	}
+
+	writeAudit(c, "email_verified", user.ID, user.ID, "email="+user.Email)
+
+	success(c, gin.H{
+		"userId":        user.ID,
+		"email":         user.Email,
+		"emailVerified": true,
+	})
+}
+
+// POST /v1/users/verify/resend — ответ всегда одинаковый, как у password/forgot
+func handleResendVerification(c *gin.Context) {
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		fail(c, http.StatusBadRequest, "VALIDATION_ERROR", err.Error())
+		return
+	}
+
+	user, err := getUserByEmail(req.Email)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to query user")
+		return
+	}
+
+	if user != nil && user.DeletedAt == nil && user.Active && !user.EmailVerified {
+		if err := sendEmailVerification(c, user); err != nil {
+			fail(c, http.StatusInternalServerError, "DB_ERROR", "Failed to save verification token")
+			return
+		}
+	} else {
+		log.Printf("requestId=%s verification resend skipped: unknown, inactive or already verified account", getRequestID(c))
+	}
+
+	success(c, gin.H{
+		"message": "If the account exists and is not verified, a verification email has been sent",
+	})
+}